@@ -0,0 +1,495 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+)
+
+// the default Layout
+const dataStartAddr = 0x10000
+const initialSP = 0x0afffffc
+const stackSize = 0x00500000
+
+// textStartAddr is the default address of the first instruction (Legalize puts .text right after the ELF header and the 3 segment headers)
+const textStartAddr = ProgEntryAddr + ElfHeaderSize + ElfProgHeaderSize*3
+
+// instSize : every STRAIGHT instruction is 32bit
+const instSize = 4
+
+// symbolTable : label -> address
+type symbolTable map[string]uint64
+
+func (t symbolTable) equals(u symbolTable) bool {
+	if len(t) != len(u) {
+		return false
+	}
+	for k, v := range t {
+		if w, ok := u[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// asmEnv is the context an instruction is parsed in.
+// pc is the address of the instruction itself. A nil *asmEnv accepts numeric operands only.
+// consts are the constants defined so far (.equ/.set) and prevConsts the ones of the previous pass (for forward references).
+type asmEnv struct {
+	pc         uint64
+	symbols    symbolTable
+	consts     constTable
+	prevConsts constTable
+}
+
+// branchImm parses the target of a branch or a jump.
+// The target is a raw PC-relative immediate or an address (an expression with a label or '.'),
+// which is converted to the distance in instructions from env.pc and has to fit in a bits-wide signed field.
+func branchImm(s string, bits uint, env *asmEnv) (uint32, error) {
+	v, err := evalExpr(s, env)
+	if err != nil {
+		return 0, err
+	}
+	if !v.addr {
+		return checkImm(s, v.val, bits, immSigned)
+	}
+
+	diff := v.val - int64(env.pc)
+	if diff%instSize != 0 {
+		return 0, fmt.Errorf("branch target '%s' (0x%x) is not aligned to an instruction", s, v.val)
+	}
+	off := diff / instSize
+	if off < -(1<<(bits-1)) || off >= 1<<(bits-1) {
+		return 0, withCode("branch-range", fmt.Errorf("branch target '%s' out of range: offset %d does not fit in %d bit", s, off, bits))
+	}
+	return uint32(off) & (1<<bits - 1), nil
+}
+
+func strToInst(s string, env *asmEnv) (instruction, error) {
+	ss := splitFields(s)
+	if len(ss) == 0 {
+		return nil, fmt.Errorf("no instruction")
+	}
+
+	if _, ok := strToSBOperation[ss[0]]; ok {
+		i, err := fromStringToInstTypeSB(s, env)
+		return instruction(i), err
+	}
+
+	if _, ok := strToMacOperation[ss[0]]; ok {
+		i, err := fromStringToInstTypeMAC(s)
+		return instruction(i), err
+	}
+
+	if _, ok := strToOneRegOperation[ss[0]]; ok {
+		i, err := fromStringToInstTypeOneReg(s, env)
+		return instruction(i), err
+	}
+
+	if _, ok := strToTwoRegOperation[ss[0]]; ok {
+		i, err := fromStringToInstTypeTwoReg(s)
+		return instruction(i), err
+	}
+
+	if _, ok := strToNoRegOperation[ss[0]]; ok {
+		i, err := fromStringToInstTypeNoReg(s, env)
+		return instruction(i), err
+	}
+
+	if _, ok := strToFloatOperation[ss[0]]; ok {
+		i, err := fromStringToInstTypeFloat(s)
+		return instruction(i), err
+	}
+
+	return nil, withCode("unknown-instruction", fmt.Errorf("unknown instruction '%s'%s", ss[0], didYouMean(ss[0], mnemonics())),
+		replacements(ss[0], mnemonics())...)
+}
+
+// maxPasses : limit of the passes to settle the layout
+const maxPasses = 10
+
+// maxRounds : limit of the rounds of inserting instructions (each round settles the layout)
+const maxRounds = 100
+
+// assembler holds the state of an assembly: the statements are processed in passes
+// until the addresses settle, then once more to generate the contents of the sections.
+type assembler struct {
+	stmts       []*statement
+	symbols     symbolTable // defined in the previous pass
+	defined     symbolTable // defined in this pass
+	consts      constTable  // .equ/.set of the previous pass
+	curConsts   constTable  // .equ/.set of this pass
+	sections    []*section
+	cur         *section
+	final       bool
+	entry       uint64
+	opts        Options
+	files       map[string][]byte             // contents of the files read by .incbin
+	far         map[*statement]bool           // CALL/TAIL in the far form
+	passes      int                           // the number of the passes done
+	stmtNo      int                           // the index of the statement being processed
+	values      map[string]valueDef           // named values defined so far in this pass
+	joins       map[string]bool               // labels that are branch targets
+	placed      []placement                   // where the statements are in this pass
+	longRefs    []longRef                     // references beyond maxDistance in this pass (with opts.Relay)
+	relayed     map[*statement]*statement     // relay -> the value relayed
+	relays      int                           // the number of the relays inserted
+	hiddenNames int                           // the number of the names given by hiddenRef
+	insts       []emitted                     // the instructions of the final pass
+	joinValues  map[*statement]map[string]int // the distances of the values at a join padded
+	paddings    []*padding                    // the instructions inserted for the joins
+	diags       Diagnostics                   // the errors and the warnings
+	source      []srcLine                     // the lines of the file before the preprocessor (for the listing)
+	data        map[int]placement             // statement -> the data it puts in the final pass
+	shown       map[int]string                // statement -> the instruction as the listing shows it (the final pass)
+}
+
+// directives : directive -> handler
+var directives = map[string]func(a *assembler, st *statement) error{
+	".text":    (*assembler).dirSection,
+	".data":    (*assembler).dirSection,
+	".rodata":  (*assembler).dirSection,
+	".bss":     (*assembler).dirSection,
+	".section": (*assembler).dirSection,
+	".byte":    (*assembler).dirData,
+	".half":    (*assembler).dirData,
+	".short":   (*assembler).dirData,
+	".2byte":   (*assembler).dirData,
+	".word":    (*assembler).dirData,
+	".long":    (*assembler).dirData,
+	".4byte":   (*assembler).dirData,
+	".dword":   (*assembler).dirData,
+	".quad":    (*assembler).dirData,
+	".8byte":   (*assembler).dirData,
+	".float":   (*assembler).dirFloat,
+	".double":  (*assembler).dirFloat,
+	".ascii":   (*assembler).dirString,
+	".asciz":   (*assembler).dirString,
+	".string":  (*assembler).dirString,
+	".space":   (*assembler).dirSpace,
+	".zero":    (*assembler).dirSpace,
+	".align":   (*assembler).dirAlign,
+	".p2align": (*assembler).dirAlign,
+	".balign":  (*assembler).dirAlign,
+	".incbin":  (*assembler).dirIncbin,
+	".equ":     (*assembler).dirEqu,
+	".set":     (*assembler).dirEqu,
+	".assert":  (*assembler).dirAssert,
+	".func":    (*assembler).dirFunc,
+	".endfunc": (*assembler).dirFunc,
+}
+
+// pass processes all the statements. The errors are collected in the final pass;
+// in the others they only leave the statements out, as the layout may change yet.
+func (a *assembler) pass(final bool) {
+	a.final = final
+	a.defined = symbolTable{}
+	a.curConsts = constTable{}
+	for name, v := range a.opts.Defines {
+		a.curConsts[name] = constant{val: exprValue{val: v}}
+	}
+	a.entry = a.opts.Layout.textStart()
+	for _, s := range a.sections {
+		s.size = 0
+		s.data = nil
+	}
+	if len(a.sections) > 0 {
+		a.entry = a.sections[0].addr // .text, which an .align can move from the start
+	}
+	a.values = map[string]valueDef{}
+	a.placed = make([]placement, len(a.stmts))
+	a.longRefs = nil
+	a.insts = nil
+	a.data = map[int]placement{}
+	a.shown = map[int]string{}
+	a.switchSection(".text")
+	for i, st := range a.stmts {
+		a.stmtNo = i
+		if err := a.statement(st); err != nil && final {
+			a.diags.add(sevError, lineError(st.line, st.pos(), err))
+		}
+		if p := a.placed[i]; final && p.end == p.start && a.cur == p.sec && a.cur.pc() > p.start {
+			a.data[i] = placement{sec: p.sec, start: p.start, end: a.cur.pc()}
+		}
+	}
+	a.symbols = a.defined
+	a.consts = a.curConsts
+	a.passes++
+}
+
+func (a *assembler) env() *asmEnv {
+	return &asmEnv{pc: a.cur.pc(), symbols: a.symbols, consts: a.curConsts, prevConsts: a.consts}
+}
+
+func (a *assembler) statement(st *statement) error {
+	a.placed[a.stmtNo] = placement{sec: a.cur, start: a.cur.pc(), end: a.cur.pc()}
+	for _, l := range st.labels {
+		if _, ok := a.defined[l.text]; ok {
+			return fmt.Errorf("label '%s' is defined more than once", l.text)
+		}
+		if _, ok := a.curConsts[l.text]; ok {
+			return fmt.Errorf("label '%s' is already defined as a constant", l.text)
+		}
+		a.defined[l.text] = a.cur.pc()
+	}
+	a.defineJoinValues(st)
+	if st.entry {
+		if !a.cur.exec {
+			return fmt.Errorf("the entry point '!' has to be in an executable section")
+		}
+		a.entry = a.cur.pc()
+	}
+
+	if st.name != nil && (st.mnemonic == nil || st.isDirective()) {
+		return fmt.Errorf("%%%s = has to name an instruction", st.name.text)
+	}
+	if st.mnemonic == nil {
+		if len(st.operands) > 0 {
+			return a.legacyData(st)
+		}
+		return nil
+	}
+
+	if dir, ok := directives[st.mnemonic.text]; ok {
+		return dir(a, st)
+	}
+	if st.mnemonic.text[0] == '.' {
+		return withCode("unknown-directive", fmt.Errorf("unknown directive %s%s", st.mnemonic.text, didYouMean(st.mnemonic.text, directiveNames())),
+			replacements(st.mnemonic.text, directiveNames())...)
+	}
+
+	if !a.cur.exec {
+		return fmt.Errorf("instruction in non-executable section %s", a.cur.name)
+	}
+	resolved, err := a.resolveValues(st)
+	if err != nil {
+		return err
+	}
+	if a.final {
+		a.shown[a.stmtNo] = shownText(st, resolved)
+	}
+	st = resolved
+	insts := []string{st.String()}
+	if expand, ok := pseudoInsts[st.mnemonic.text]; ok {
+		var err error
+		if insts, err = expand(a, st); err != nil {
+			return err
+		}
+	}
+	for _, s := range insts {
+		var bs [4]byte
+		if a.final {
+			i, err := strToInst(s, a.env())
+			if err != nil {
+				return err
+			}
+			a.checkInst(st, s, i)
+			bs = instToBytes(i)
+			a.insts = append(a.insts, emitted{sec: a.cur, pc: a.cur.pc(), inst: i, stmt: a.stmtNo, text: s})
+		}
+		a.cur.emit(bs[:])
+	}
+	a.placed[a.stmtNo].end = a.cur.pc()
+	if len(insts) > 0 {
+		a.defineValue(st)
+	}
+	return nil
+}
+
+// legacyData handles a line of bytes separated by spaces ("Initialize values" form)
+func (a *assembler) legacyData(st *statement) error {
+	if a.cur.exec {
+		return fmt.Errorf("data in executable section %s", a.cur.name)
+	}
+	for _, op := range st.operands {
+		var d uint32
+		if a.final {
+			var err error
+			d, err = parseImm(joinTokens(op), 8, immAny, a.env())
+			if err != nil {
+				return fmt.Errorf("invalid data: %w", err)
+			}
+			if d != 0 && a.cur.nobits {
+				return fmt.Errorf("non-zero data in @nobits section %s", a.cur.name)
+			}
+		}
+		a.cur.emit([]byte{byte(d)})
+	}
+	return nil
+}
+
+// load reads the source into statements
+func (a *assembler) load(fileName string, r io.Reader) error {
+	a.opts.Layout = a.opts.Layout.withDefaults()
+	lines, err := lexFile(fileName, r)
+	if err != nil {
+		return err
+	}
+	a.source = lines
+	for i := range lines {
+		if joinTokens(lines[i].tokens) == "Initialize values" {
+			// old form of .data: the rest of the file used to be data
+			lines[i].tokens = lines[i].tokens[:1]
+			lines[i].tokens[0].text = ".data"
+		}
+	}
+
+	pp := newPreprocessor(a.opts.IncludeDirs, a.opts.Defines)
+	pp.files = []string{fileName}
+	if err := pp.process(lines, 0); err != nil {
+		pp.diags.add(sevError, err)
+	}
+	check := legacyOperands
+	if a.opts.Syntax == "paper" {
+		check = paperOperands
+	}
+	diags := pp.diags // and the errors of the operands of the lines expanded
+	for i := range pp.out {
+		st := parseStatement(&pp.out[i])
+		if st.mnemonic != nil && !st.isDirective() {
+			if err := check(st); err != nil {
+				diags.add(sevError, err)
+			}
+		}
+		a.stmts = append(a.stmts, st)
+	}
+	if diags.Errors() > 0 {
+		return diags
+	}
+	if err := renameLocalLabels(a.stmts); err != nil {
+		return err
+	}
+	a.stmts = attachLabels(a.stmts)
+	a.findJoins()
+	a.far = map[*statement]bool{}
+	a.relayed = map[*statement]*statement{}
+	a.joinValues = map[*statement]map[string]int{}
+	return nil
+}
+
+// run assembles the loaded statements into the sections
+func (a *assembler) run() error {
+	if err := a.settle(); err != nil {
+		return err
+	}
+	a.sourceDistances()
+	if a.opts.Relay || a.opts.Pad {
+		if err := a.insertInstructions(); err != nil {
+			return err
+		}
+	}
+	a.pass(true)
+	if a.diags.Errors() == 0 {
+		a.checkLabels()
+		a.checkFlow()
+		a.checkPadding()
+	}
+	if a.opts.Verify {
+		if err := a.verifyJoins(); err != nil {
+			a.diags.add(sevError, err)
+		}
+	}
+	if a.diags.Errors() > 0 {
+		return a.diags
+	}
+	return nil
+}
+
+// insertInstructions inserts the padding of the joins and the relays until the layout needs no more
+func (a *assembler) insertInstructions() error {
+	a.symbolizeDistances()
+	for n := 1; ; n++ {
+		if err := a.settle(); err != nil {
+			return err
+		}
+		changes := 0
+		if a.opts.Pad {
+			var err error
+			if changes, err = a.padJoins(); err != nil {
+				return err
+			}
+		}
+		if changes == 0 && a.opts.Relay {
+			changes = a.insertRelays()
+		}
+		if changes == 0 {
+			return nil
+		}
+		if n == maxRounds {
+			return fmt.Errorf("the inserted instructions do not settle after %d rounds", n)
+		}
+	}
+}
+
+// settle repeats the passes until the layout settles
+func (a *assembler) settle() error {
+	for n := 1; ; n++ {
+		prev, prevConsts := a.symbols, a.consts
+		a.pass(false)
+		changed, err := a.layout()
+		if err != nil {
+			return err
+		}
+		if !changed && a.symbols.equals(prev) && a.consts.equals(prevConsts) {
+			return nil
+		}
+		if n == maxPasses {
+			return fmt.Errorf("the layout does not settle after %d passes", n)
+		}
+	}
+}
+
+// buildELF makes the ELF file of the program: the segments of the text, the stack and the global data
+func (a *assembler) buildELF() *ElfFile {
+	l := a.opts.Layout
+	elf := NewELFFile()
+	elf.EntryOffset = a.entry - l.textStart()
+	prog := a.segmentImage(true, l.textStart())
+	datumbytes := make([]byte, l.DataAddr)
+	datumbytes = append(datumbytes, a.segmentImage(false, l.DataAddr)...)
+
+	progHeader := ElfProgHeader{
+		ProgType:     ProgTypeLoad,
+		ProgFlags:    ProgFlagExecute + ProgFlagRead,
+		ProgVAddr:    ElfAddr(l.TextAddr),
+		ProgPAddr:    0,
+		ProgFileSize: uint64(len(prog)), // あとでlegalize
+		Prog:         prog,
+	}
+	elf.AddSegment(&progHeader)
+
+	stackHeader := ElfProgHeader{
+		ProgType:     ProgTypeLoad,
+		ProgFlags:    ProgFlagWrite + ProgFlagRead,
+		ProgVAddr:    ElfAddr(l.InitialSP - l.StackSize),
+		ProgPAddr:    0,
+		ProgFileSize: 0,
+		ProgMemSize:  l.StackSize,
+		Prog:         nil,
+	}
+	elf.AddSegment(&stackHeader)
+
+	globalDataHeader := ElfProgHeader{
+		ProgType:     ProgTypeLoad,
+		ProgFlags:    ProgFlagWrite + ProgFlagRead,
+		ProgVAddr:    0,
+		ProgPAddr:    0,
+		ProgFileSize: uint64(len(datumbytes)),
+		ProgMemSize:  globalDataSize,
+		Prog:         datumbytes,
+	}
+	elf.AddSegment(&globalDataHeader)
+
+	secHeader := ElfSecHeader{
+		SecType: SecTypeNull,
+	}
+	elf.Sections = append(elf.Sections, &secHeader)
+
+	secStrTable := ElfSecHeader{
+		SecType: SecTypeStrTab,
+		Sec:     make([]byte, 20),
+	}
+	secStrTable.Sec[0] = 0x0
+	copy(secStrTable.Sec[1:], "DummySectionHeader")
+	elf.Sections = append(elf.Sections, &secStrTable)
+	return elf
+}
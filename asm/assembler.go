@@ -0,0 +1,127 @@
+package asm
+
+import (
+	"io"
+)
+
+// Layout : the addresses the program is put at (a zero field is the default)
+type Layout struct {
+	TextAddr  uint64 // the text segment; .text follows the ELF header and the segment headers in it (ProgEntryAddr)
+	DataAddr  uint64 // the first data section in the global data region (0x10000)
+	InitialSP uint64 // the initial stack pointer, the top of the stack segment (0x0afffffc)
+	StackSize uint64 // the size of the stack segment (0x500000)
+}
+
+// withDefaults returns the layout with the zero fields set to the defaults
+func (l Layout) withDefaults() Layout {
+	if l.TextAddr == 0 {
+		l.TextAddr = ProgEntryAddr
+	}
+	if l.DataAddr == 0 {
+		l.DataAddr = dataStartAddr
+	}
+	if l.InitialSP == 0 {
+		l.InitialSP = initialSP
+	}
+	if l.StackSize == 0 {
+		l.StackSize = stackSize
+	}
+	return l
+}
+
+// textStart returns the address of the first instruction
+func (l Layout) textStart() uint64 {
+	return l.TextAddr + ElfHeaderSize + ElfProgHeaderSize*3
+}
+
+// Options : options of the assembler (the zero value assembles as the command does without flags)
+type Options struct {
+	Layout      Layout
+	IncludeDirs []string         // -I: directories searched by .include and .incbin
+	Defines     map[string]int64 // -D NAME=value: constants defined before the source
+	Relay       bool             // -relay: insert RMOV relays for the values beyond maxDistance
+	Verify      bool             // -verify: check the distances at the control flow joins
+	Pad         bool             // -pad: insert NOPs or RMOVs for the named values at the control flow joins
+	Syntax      string           // -syntax: the dialect of the operands ("legacy" (or "") or "paper")
+	Warnings    map[string]bool  // -Wall, -W<name>, -Wno-<name>: the warnings enabled (nil: the default ones)
+	Werror      bool             // -Werror: the warnings are errors
+}
+
+// Assembler assembles sources with its options. It keeps nothing between the assemblies,
+// so one can be used by several goroutines at once.
+type Assembler struct {
+	opts Options
+}
+
+// New returns an assembler with the options
+func New(opts Options) *Assembler {
+	return &Assembler{opts: opts}
+}
+
+// Object is an assembled program
+type Object struct {
+	Diagnostics Diagnostics // the warnings and the notes
+	a           *assembler
+	elf         *ElfFile
+}
+
+// Assemble assembles the source read from r (named "-" in the diagnostics)
+func (as *Assembler) Assemble(r io.Reader) (*Object, error) {
+	return as.AssembleNamed("-", r)
+}
+
+// AssembleNamed assembles the source read from r. name is the file in the diagnostics
+// and .include and .incbin look in its directory first.
+// The error is the Diagnostics (with the warnings) when the source has errors.
+func (as *Assembler) AssembleNamed(name string, r io.Reader) (*Object, error) {
+	a := &assembler{opts: as.opts}
+	if err := a.load(name, r); err != nil {
+		var diags Diagnostics
+		diags.add(sevError, err)
+		return nil, diags
+	}
+	if err := a.run(); err != nil {
+		var diags Diagnostics
+		diags.add(sevError, err) // with the warnings
+		return nil, diags
+	}
+	o := &Object{Diagnostics: a.diags, a: a}
+	if a.opts.Relay {
+		o.Diagnostics.note("relay", srcPos{file: name}, "inserted %d RMOV relays", a.relays)
+	}
+	for _, p := range a.paddings {
+		o.Diagnostics.note("pad", a.labelPos(p.label), "label '%s': inserted %d NOP, %d RMOV", p.label, p.nops, p.rmovs)
+	}
+	o.elf = a.buildELF()
+	o.elf.Legalize()
+	return o, nil
+}
+
+// Entry returns the address of the entry point
+func (o *Object) Entry() uint64 {
+	return o.a.entry
+}
+
+// Symbol returns the address of the label (the local labels are not exported)
+func (o *Object) Symbol(name string) (uint64, bool) {
+	if isLocalLabel(name) {
+		return 0, false
+	}
+	addr, ok := o.a.symbols[name]
+	return addr, ok
+}
+
+// WriteELF writes the executable
+func (o *Object) WriteELF(w io.Writer) error {
+	return o.elf.WriteELF(w)
+}
+
+// WriteListing writes the source with the addresses and the encodings (-listing)
+func (o *Object) WriteListing(w io.Writer) error {
+	return o.a.writeListing(w)
+}
+
+// WriteMap writes the layout of the segments, the sections and the symbols (-map)
+func (o *Object) WriteMap(w io.Writer) error {
+	return o.a.writeMap(w, o.elf)
+}
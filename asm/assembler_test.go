@@ -0,0 +1,86 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAssemblerConcurrent(t *testing.T) {
+	as := New(Options{})
+	srcs := make([]string, 16)
+	for i := range srcs {
+		// a different entry in each source
+		srcs[i] = strings.Repeat("NOP\n", i) + "! start: LUi 1\nECALL\n"
+	}
+	elfs := make([][]byte, len(srcs))
+	var wg sync.WaitGroup
+	for i, src := range srcs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				obj, err := as.Assemble(strings.NewReader(src))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var b bytes.Buffer
+				if err := obj.WriteELF(&b); err != nil {
+					t.Error(err)
+					return
+				}
+				if k > 0 && !bytes.Equal(b.Bytes(), elfs[i]) {
+					t.Errorf("%d: the ELF differs between the assemblies", i)
+				}
+				elfs[i] = b.Bytes()
+			}
+		}()
+	}
+	wg.Wait()
+	for i, elf := range elfs {
+		if len(elf) < ElfHeaderSize {
+			t.Fatalf("%d: %d bytes", i, len(elf))
+		}
+		if entry := binary.LittleEndian.Uint64(elf[24:]); entry != textStartAddr+uint64(i)*instSize {
+			t.Errorf("%d: entry 0x%x", i, entry)
+		}
+	}
+}
+
+func TestAssemblerOptions(t *testing.T) {
+	as := New(Options{Layout: Layout{TextAddr: 0x1000000, DataAddr: 0x20000}, Syntax: "paper"})
+	obj, err := as.Assemble(strings.NewReader("! start: LUi 1\n.Lx: ADD.64 [1], [1]\nECALL\n.data\nd: .byte 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := uint64(0x1000000 + ElfHeaderSize + ElfProgHeaderSize*3)
+	if obj.Entry() != text {
+		t.Errorf("entry 0x%x", obj.Entry())
+	}
+	if d, ok := obj.Symbol("d"); !ok || d != 0x20000 {
+		t.Errorf("d: 0x%x %v", d, ok)
+	}
+	for name := range obj.a.symbols {
+		if _, ok := obj.Symbol(name); ok && isLocalLabel(name) {
+			t.Errorf("the local label %s is exported", name)
+		}
+	}
+	var b strings.Builder
+	if err := obj.WriteMap(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("Entry point  0x%08x\n", text); !strings.Contains(b.String(), want) {
+		t.Errorf("no %q in\n%s", want, b.String())
+	}
+
+	_, err = New(Options{Werror: true}).Assemble(strings.NewReader("SLTiu.64 1, 0x800\nECALL\n"))
+	var diags Diagnostics
+	if !errors.As(err, &diags) || diags.Errors() != 1 || !strings.Contains(err.Error(), "-:1:1: ") {
+		t.Errorf("%v", err)
+	}
+}
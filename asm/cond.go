@@ -0,0 +1,150 @@
+package asm
+
+import (
+	"fmt"
+)
+
+// condOpeners : the directives that start a conditional block
+var condOpeners = []string{".if", ".ifdef", ".ifndef"}
+
+// condBranch is a part of a conditional block: the line that starts it and the lines up to the next one
+type condBranch struct {
+	head *srcLine
+	dir  string
+	ops  []token
+	body []srcLine
+}
+
+// env returns the constants known to the preprocessor
+func (p *preprocessor) env() *asmEnv {
+	return &asmEnv{consts: p.consts}
+}
+
+// record remembers the labels and the constants of a line passed to the assembler
+func (p *preprocessor) record(l *srcLine, d string, ops []token) {
+	for _, t := range parseStatement(l).labels {
+		p.names[t.text] = true
+	}
+	if d != ".equ" && d != ".set" {
+		return
+	}
+	args := splitTokensByComma(ops)
+	if len(args) != 2 {
+		return // reported by the assembler
+	}
+	name, err := symbolOperand(args[0])
+	if err != nil {
+		return
+	}
+	p.names[name] = true
+	v, err := evalExpr(joinTokens(args[1]), p.env())
+	if err != nil || v.addr {
+		delete(p.consts, name) // depends on the layout
+		return
+	}
+	p.consts[name] = constant{val: v}
+}
+
+// userError returns the error of
+//
+//	.error "message"
+func (p *preprocessor) userError(ops []token) error {
+	if len(ops) != 1 || ops[0].kind != tokString {
+		return fmt.Errorf(`usage: .error "message"`)
+	}
+	msg, err := unquoteString(ops[0].text)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// conditional selects the lines of
+//
+//	.if expr | .ifdef name | .ifndef name
+//	...
+//	[.elseif expr
+//	...]...
+//	[.else
+//	...]
+//	.endif
+//
+// and returns them with the number of lines consumed (0 when the block has no end).
+// The expressions may only use constants defined before (-D and .equ/.set of numbers).
+func (p *preprocessor) conditional(lines []srcLine) ([]srcLine, int, error) {
+	d, ops := directiveOf(&lines[0])
+	branches := []condBranch{{head: &lines[0], dir: d, ops: ops}}
+	start, depth, n := 1, 1, 0
+	var misplaced error
+	for i := 1; i < len(lines) && n == 0; i++ {
+		d, ops := directiveOf(&lines[i])
+		for _, o := range condOpeners {
+			if d == o {
+				depth++
+			}
+		}
+		switch {
+		case d == ".endif":
+			depth--
+			if depth == 0 {
+				branches[len(branches)-1].body = lines[start:i]
+				n = i + 1
+			}
+		case depth == 1 && (d == ".elseif" || d == ".else"):
+			last := &branches[len(branches)-1]
+			if last.dir == ".else" && misplaced == nil {
+				misplaced = lineError(&lines[i], lines[i].pos, fmt.Errorf("%s after .else", d))
+			}
+			last.body = lines[start:i]
+			branches = append(branches, condBranch{head: &lines[i], dir: d, ops: ops})
+			start = i + 1
+		}
+	}
+	if n == 0 {
+		return nil, 0, lineError(&lines[0], lines[0].pos, fmt.Errorf("%s without .endif", branches[0].dir))
+	}
+	if misplaced != nil {
+		return nil, n, misplaced
+	}
+
+	for _, b := range branches {
+		ok, err := p.condition(b.dir, b.ops)
+		if err != nil {
+			return nil, n, lineError(b.head, b.head.pos, err)
+		}
+		if ok {
+			return b.body, n, nil
+		}
+	}
+	return nil, n, nil
+}
+
+// condition evaluates the condition of a branch of a conditional block
+func (p *preprocessor) condition(d string, ops []token) (bool, error) {
+	switch d {
+	case ".else":
+		if len(ops) != 0 {
+			return false, fmt.Errorf(".else takes no operands")
+		}
+		return true, nil
+
+	case ".ifdef", ".ifndef":
+		name, err := symbolOperand(ops)
+		if err != nil {
+			return false, err
+		}
+		return p.names[name] == (d == ".ifdef"), nil
+	}
+
+	if len(ops) == 0 {
+		return false, fmt.Errorf("%s needs a condition", d)
+	}
+	v, err := evalExpr(joinTokens(ops), p.env())
+	if err != nil {
+		return false, fmt.Errorf("%w (the condition of %s can only use -D and .equ/.set constants defined before)", err, d)
+	}
+	if v.addr {
+		return false, fmt.Errorf("the condition of %s '%s' depends on an address", d, joinTokens(ops))
+	}
+	return v.val != 0, nil
+}
@@ -0,0 +1,100 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConditional(t *testing.T) {
+	var table = []struct {
+		in       string
+		expected string
+	}{
+		{".if 1\nRMOV 1\n.else\nRMOV 2\n.endif", "RMOV 1"},
+		{".if 0\nRMOV 1\n.elseif 2 > 1\nRMOV 2\n.else\nRMOV 3\n.endif", "RMOV 2"},
+		{".if 0\nRMOV 1\n.elseif 0\nRMOV 2\n.else\nRMOV 3\n.endif", "RMOV 3"},
+		{".if 0\n.if 1\nRMOV 1\n.endif\n.else\nRMOV 2\n.endif", "RMOV 2"},
+		{".equ N, 4\n.if N * 2 == 8\nRMOV 1\n.endif", ".equ N, 4\nRMOV 1"},
+		{".set N, 1\n.set N, N + 1\n.if N == 2\nRMOV 1\n.endif", ".set N, 1\n.set N, N + 1\nRMOV 1"},
+		{"l: NOP\n.ifdef l\nRMOV 1\n.endif\n.ifndef m\nRMOV 2\n.endif", "l:\nNOP\nRMOV 1\nRMOV 2"},
+		{".if 0\n.error \"never\"\n.endif", ""},
+		{".macro m x\n.if \\x\nRMOV 1\n.else\nRMOV 0\n.endif\n.endm\nm 0\nm 1", "RMOV 0\nRMOV 1"},
+	}
+	for _, e := range table {
+		actual, err := preprocessString(e.in)
+		if err != nil {
+			t.Error(err)
+		} else if actual != e.expected {
+			t.Errorf("%q: %q (expected %q)", e.in, actual, e.expected)
+		}
+	}
+}
+
+func TestConditionalErrors(t *testing.T) {
+	var table = []struct {
+		in       string
+		expected string
+	}{
+		{".if 1\nNOP", "test.s:1:1: .if without .endif"},
+		{".endif", "test.s:1:1: unexpected .endif"},
+		{".if 1\n.else\n.else\n.endif", "test.s:3:1: .else after .else"},
+		{".if x\n.endif", "test.s:1:1: invalid expression 'x'"},
+		{".if .\n.endif", "depends on an address"},
+		{".error \"no float support\"", "test.s:1:1: no float support"},
+	}
+	for _, e := range table {
+		_, err := preprocessString(e.in)
+		if err == nil || !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%q: %v (expected %q)", e.in, err, e.expected)
+		}
+	}
+}
+
+func TestDefines(t *testing.T) {
+	a := assembler{opts: Options{Defines: map[string]int64{"FLOAT": 1, "STACK": 0x100}}}
+	src := `
+.ifdef FLOAT
+.equ SIZE, STACK * 2
+.else
+.equ SIZE, STACK
+.endif
+ADDi.64 1, SIZE
+.data
+d: .word SIZE, end - d
+.assert SIZE == 0x200, "wrong size"
+end:
+`
+	if err := a.load("test.s", strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(); err != nil {
+		t.Fatal(err)
+	}
+	if d := a.sections[1].data; string(d) != "\x00\x02\x00\x00\x08\x00\x00\x00" {
+		t.Errorf(".data: %v", d)
+	}
+
+	for _, src := range []string{".assert 1 == 2", ".equ A, 1\n.equ A, 2", ".equ A, 1\n.set A, 2", "l:\n.equ l, 1"} {
+		a := assembler{}
+		if err := a.load("test.s", strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("%q should be an error", src)
+		}
+	}
+
+	for _, e := range []struct {
+		in   string
+		name string
+		val  int64
+	}{{"A=0x10", "A", 16}, {"B", "B", 1}, {"C=1<<4", "C", 16}} {
+		name, v, err := ParseDefine(e.in)
+		if err != nil || name != e.name || v != e.val {
+			t.Error(e.in, name, v, err)
+		}
+	}
+	if _, _, err := ParseDefine("1A=2"); err == nil {
+		t.Error("1A should be an invalid name")
+	}
+}
@@ -0,0 +1,128 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// constant is a symbol defined by .equ/.set (or -D)
+type constant struct {
+	val exprValue
+	set bool // defined by .set: it can be defined again
+}
+
+// constTable : name -> constant
+type constTable map[string]constant
+
+func (t constTable) equals(u constTable) bool {
+	if len(t) != len(u) {
+		return false
+	}
+	for k, v := range t {
+		if w, ok := u[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// isSymbolName reports whether s can be the name of a label or a constant
+func isSymbolName(s string) bool {
+	if s == "" || s == "." || !isSymbolChar(s[0], true) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isSymbolChar(s[i], false) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseDefine parses the -D option "NAME=value" ("NAME" alone defines 1)
+func ParseDefine(s string) (string, int64, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !isSymbolName(name) {
+		return "", 0, fmt.Errorf("invalid name in -D %s", s)
+	}
+	if !ok {
+		return name, 1, nil
+	}
+	v, err := evalExpr(value, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid value in -D %s: %w", s, err)
+	}
+	return name, v.val, nil
+}
+
+// symbolOperand returns the name given as the operand op
+func symbolOperand(op []token) (string, error) {
+	if len(op) != 1 || op[0].kind != tokIdent || !isSymbolName(op[0].text) {
+		return "", fmt.Errorf("invalid symbol name '%s'", joinTokens(op))
+	}
+	return op[0].text, nil
+}
+
+// dirEqu handles
+//
+//	.equ name, expr
+//	.set name, expr
+//
+// A name defined by .set can be defined again; the new value is used from there on.
+func (a *assembler) dirEqu(st *statement) error {
+	dir := st.mnemonic.text
+	if len(st.operands) != 2 {
+		return fmt.Errorf("usage: %s name, expr", dir)
+	}
+	name, err := symbolOperand(st.operands[0])
+	if err != nil {
+		return err
+	}
+	if _, ok := a.defined[name]; ok {
+		return fmt.Errorf("'%s' is already defined as a label", name)
+	}
+	set := dir == ".set"
+	if old, ok := a.curConsts[name]; ok && !(old.set && set) {
+		return fmt.Errorf("constant '%s' is already defined", name)
+	}
+
+	v, err := evalExpr(joinTokens(st.operands[1]), a.env())
+	if err != nil {
+		if a.final {
+			return err
+		}
+		v = exprValue{}
+	}
+	a.curConsts[name] = constant{val: v, set: set}
+	return nil
+}
+
+// dirAssert handles
+//
+//	.assert expr[, "message"]
+//
+// which fails when expr is 0 (checked after the layout is settled)
+func (a *assembler) dirAssert(st *statement) error {
+	if len(st.operands) != 1 && len(st.operands) != 2 {
+		return fmt.Errorf(`usage: .assert expr[, "message"]`)
+	}
+	if !a.final {
+		return nil
+	}
+	ops := st.operandTexts()
+	v, err := evalExpr(ops[0], a.env())
+	if err != nil {
+		return err
+	}
+	if v.val != 0 {
+		return nil
+	}
+	if len(ops) == 2 {
+		msg, err := unquoteString(ops[1])
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("assertion failed: %s", msg)
+	}
+	return fmt.Errorf("assertion failed: %s", ops[0])
+}
@@ -0,0 +1,61 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// csrNames : CSR name -> number (the numbers of the RISC-V specifications)
+var csrNames = map[string]uint32{
+	// floating point
+	"fflags": 0x001,
+	"frm":    0x002,
+	"fcsr":   0x003,
+
+	// counters
+	"cycle":   0xc00,
+	"time":    0xc01,
+	"instret": 0xc02,
+
+	// machine information
+	"mvendorid": 0xf11,
+	"marchid":   0xf12,
+	"mimpid":    0xf13,
+	"mhartid":   0xf14,
+
+	// machine trap setup
+	"mstatus":    0x300,
+	"misa":       0x301,
+	"medeleg":    0x302,
+	"mideleg":    0x303,
+	"mie":        0x304,
+	"mtvec":      0x305,
+	"mcounteren": 0x306,
+
+	// machine trap handling
+	"mscratch": 0x340,
+	"mepc":     0x341,
+	"mcause":   0x342,
+	"mtval":    0x343,
+	"mip":      0x344,
+
+	// machine counters
+	"mcycle":   0xb00,
+	"minstret": 0xb02,
+}
+
+// csrNumber parses the CSR operand: a name of csrNames or a 12bit number (expression)
+func csrNumber(s string, env *asmEnv) (uint32, error) {
+	if n, ok := csrNames[s]; ok {
+		return n, nil
+	}
+	if _, ok := env.lookup(s); !ok && isSymbolName(s) {
+		names := make([]string, 0, len(csrNames))
+		for name := range csrNames {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return 0, fmt.Errorf("unknown CSR '%s'%s", s, didYouMean(s, names))
+	}
+	return parseImm(s, 12, immUnsigned, env)
+}
@@ -0,0 +1,298 @@
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxSpace : limit of the size of .space (the global data region is 32 MiB)
+const maxSpace = 32 << 20
+
+// sizes of the integer data directives
+var dataDirectiveSizes = map[string]uint{
+	".byte":  1,
+	".half":  2,
+	".short": 2,
+	".2byte": 2,
+	".word":  4,
+	".long":  4,
+	".4byte": 4,
+	".dword": 8,
+	".quad":  8,
+	".8byte": 8,
+}
+
+// emitValue writes the lower size bytes of v in little endian
+func (a *assembler) emitValue(v uint64, size uint) error {
+	if v != 0 && a.cur.nobits {
+		return fmt.Errorf("non-zero data in @nobits section %s", a.cur.name)
+	}
+	var bs [8]byte
+	binary.LittleEndian.PutUint64(bs[:], v)
+	a.cur.emit(bs[:size])
+	return nil
+}
+
+// dirData handles .byte, .half, .word, .dword and their aliases:
+//
+//	.dword expr[, expr]...
+//
+// A value can be an address: ".dword table_entry".
+func (a *assembler) dirData(st *statement) error {
+	size := dataDirectiveSizes[st.mnemonic.text]
+	if len(st.operands) == 0 {
+		return fmt.Errorf("%s needs at least one value", st.mnemonic.text)
+	}
+	for _, op := range st.operandTexts() {
+		var v uint64
+		if a.final {
+			e, err := evalExpr(op, a.env())
+			if err != nil {
+				return err
+			}
+			if size < 8 {
+				if _, err := checkImm(op, e.val, size*8, immAny); err != nil {
+					return err
+				}
+			}
+			v = uint64(e.val)
+		}
+		if err := a.emitValue(v, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseFloat accepts floating point literals ("1.5e-3", "-inf", "nan") and constant expressions
+func parseFloat(s string, env *asmEnv) (float64, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	v, err := evalExpr(s, env)
+	if err != nil {
+		return 0, fmt.Errorf("invalid floating point number '%s'", s)
+	}
+	return float64(v.val), nil
+}
+
+// dirFloat handles .float (IEEE-754 binary32) and .double (binary64)
+func (a *assembler) dirFloat(st *statement) error {
+	if len(st.operands) == 0 {
+		return fmt.Errorf("%s needs at least one value", st.mnemonic.text)
+	}
+	for _, op := range st.operandTexts() {
+		var f float64
+		if a.final {
+			var err error
+			if f, err = parseFloat(op, a.env()); err != nil {
+				return err
+			}
+		}
+		var err error
+		if st.mnemonic.text == ".float" {
+			err = a.emitValue(uint64(math.Float32bits(float32(f))), 4)
+		} else {
+			err = a.emitValue(math.Float64bits(f), 8)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unquoteString interprets a string literal with the C escape sequences
+// (\n \t \r \0 \a \b \f \v \e \\ \' \" \? \xHH and \ooo)
+func unquoteString(s string) ([]byte, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return nil, fmt.Errorf("%s is not a string literal", s)
+	}
+	s = s[1 : len(s)-1]
+	var bs []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			bs = append(bs, s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return nil, fmt.Errorf("invalid escape sequence at the end of \"%s\"", s)
+		}
+		switch c := s[i]; c {
+		case 'n':
+			bs = append(bs, '\n')
+		case 't':
+			bs = append(bs, '\t')
+		case 'r':
+			bs = append(bs, '\r')
+		case 'a':
+			bs = append(bs, '\a')
+		case 'b':
+			bs = append(bs, '\b')
+		case 'f':
+			bs = append(bs, '\f')
+		case 'v':
+			bs = append(bs, '\v')
+		case 'e':
+			bs = append(bs, 0x1b)
+		case '\\', '\'', '"', '?':
+			bs = append(bs, c)
+		case 'x':
+			j := i + 1
+			for j < len(s) && j < i+3 && strings.IndexByte("0123456789abcdefABCDEF", s[j]) >= 0 {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("invalid escape sequence \\x in \"%s\"", s)
+			}
+			v, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			bs = append(bs, byte(v))
+			i = j - 1
+		default:
+			if c < '0' || '7' < c {
+				return nil, fmt.Errorf("invalid escape sequence \\%c in \"%s\"", c, s)
+			}
+			j := i
+			for j < len(s) && j < i+3 && '0' <= s[j] && s[j] <= '7' {
+				j++
+			}
+			v, _ := strconv.ParseUint(s[i:j], 8, 16)
+			if v > 0xff {
+				return nil, fmt.Errorf("octal escape sequence \\%s out of range in \"%s\"", s[i:j], s)
+			}
+			bs = append(bs, byte(v))
+			i = j - 1
+		}
+	}
+	return bs, nil
+}
+
+// dirString handles .ascii and .asciz/.string (zero-terminated)
+func (a *assembler) dirString(st *statement) error {
+	if len(st.operands) == 0 {
+		return fmt.Errorf("%s needs at least one string", st.mnemonic.text)
+	}
+	for _, op := range st.operandTexts() {
+		bs, err := unquoteString(op)
+		if err != nil {
+			return err
+		}
+		if st.mnemonic.text != ".ascii" {
+			bs = append(bs, 0)
+		}
+		if a.cur.nobits {
+			return fmt.Errorf("string in @nobits section %s", a.cur.name)
+		}
+		a.cur.emit(bs)
+	}
+	return nil
+}
+
+// constOperand evaluates an operand that decides the layout (sizes, alignment).
+// Forward references are allowed only until the last pass.
+func (a *assembler) constOperand(s string) (int64, error) {
+	v, err := evalExpr(s, a.env())
+	if err != nil && !a.final {
+		return 0, nil
+	}
+	return v.val, err
+}
+
+// dirSpace handles .space/.zero size[, fill] (NOPs in executable sections)
+func (a *assembler) dirSpace(st *statement) error {
+	ops := st.operandTexts()
+	if len(ops) != 1 && len(ops) != 2 {
+		return fmt.Errorf("usage: %s size[, fill]", st.mnemonic.text)
+	}
+	size, err := a.constOperand(ops[0])
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		if a.final {
+			return fmt.Errorf("negative size %d", size)
+		}
+		size = 0
+	}
+	if size > maxSpace {
+		return fmt.Errorf("too large size %d (> %d)", size, maxSpace)
+	}
+	var fill uint32
+	if len(ops) == 2 && a.final {
+		if fill, err = parseImm(ops[1], 8, immAny, a.env()); err != nil {
+			return err
+		}
+	}
+	if fill != 0 && a.cur.nobits {
+		return fmt.Errorf("non-zero data in @nobits section %s", a.cur.name)
+	}
+	if a.cur.exec {
+		// NOPs like the padding of .align
+		if fill != 0 {
+			return fmt.Errorf("%s with a fill in executable section %s (it is filled with NOPs)", st.mnemonic.text, a.cur.name)
+		}
+		return a.emitNops(uint64(size))
+	}
+	bs := make([]byte, size)
+	for i := range bs {
+		bs[i] = byte(fill)
+	}
+	a.cur.emit(bs)
+	return nil
+}
+
+// dirAlign handles .align/.p2align n (to 2^n bytes) and .balign n (to n bytes).
+// Executable sections are padded with NOPs (a multiple of the instruction size).
+func (a *assembler) dirAlign(st *statement) error {
+	ops := st.operandTexts()
+	if len(ops) != 1 {
+		return fmt.Errorf("usage: %s n", st.mnemonic.text)
+	}
+	n, err := a.constOperand(ops[0])
+	if err != nil {
+		return err
+	}
+	align := uint64(n)
+	if st.mnemonic.text == ".balign" {
+		if n <= 0 || n&(n-1) != 0 {
+			return fmt.Errorf("alignment %d is not a power of 2", n)
+		}
+	} else {
+		if n < 0 || n > 16 {
+			return fmt.Errorf("alignment 2^%d is out of range", n)
+		}
+		align = 1 << uint(n)
+	}
+	if a.cur.align < align {
+		a.cur.align = align
+	}
+
+	pad := alignUp(a.cur.size, align) - a.cur.size
+	if a.cur.exec {
+		return a.emitNops(pad)
+	}
+	a.cur.emit(make([]byte, pad))
+	return nil
+}
+
+// emitNops pads the executable section with size bytes of NOPs. They are instructions:
+// the distances over them count them (see checkPadding).
+func (a *assembler) emitNops(size uint64) error {
+	if size%instSize != 0 {
+		return fmt.Errorf("padding of %d bytes in executable section %s is not a multiple of the instruction size (%d)", size, a.cur.name, instSize)
+	}
+	nop := &instTypeOneReg{operation: opRPINC}
+	bs := instToBytes(nop)
+	for n := uint64(0); n < size; n += instSize {
+		if a.final {
+			a.insts = append(a.insts, emitted{sec: a.cur, pc: a.cur.pc(), inst: nop, stmt: a.stmtNo, text: "NOP"})
+		}
+		a.cur.emit(bs[:])
+	}
+	a.placed[a.stmtNo].end = a.cur.pc()
+	return nil
+}
@@ -0,0 +1,94 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDataDirectives(t *testing.T) {
+	var table = []struct {
+		in       string
+		expected []byte
+	}{
+		{".byte 1, 0xff, -1, 'A'", []byte{1, 0xff, 0xff, 'A'}},
+		{".half 0x1234, -2", []byte{0x34, 0x12, 0xfe, 0xff}},
+		{".word 0x12345678", []byte{0x78, 0x56, 0x34, 0x12}},
+		{".dword 0x0102030405060708", []byte{8, 7, 6, 5, 4, 3, 2, 1}},
+		{".float 1.5", []byte{0, 0, 0xc0, 0x3f}},
+		{".double -2", []byte{0, 0, 0, 0, 0, 0, 0, 0xc0}},
+		{`.ascii "a\tb"`, []byte{'a', '\t', 'b'}},
+		{`.asciz "\x41\101\"", "c"`, []byte{'A', 'A', '"', 0, 'c', 0}},
+		{".space 3", []byte{0, 0, 0}},
+		{".zero 2, 7", []byte{7, 7}},
+		{".byte 1\n.align 2\n.byte 2", []byte{1, 0, 0, 0, 2}},
+		{".byte 1\n.balign 2\n.byte 2", []byte{1, 0, 2}},
+		{"l: .dword l, l + 8", []byte{0, 0, 1, 0, 0, 0, 0, 0, 8, 0, 1, 0, 0, 0, 0, 0}},
+		{".space end - .\nend:", nil},
+	}
+	for _, e := range table {
+		a := assembleString(t, ".data\n"+e.in)
+		if actual := a.segmentImage(false, dataStartAddr); !bytes.Equal(actual, e.expected) {
+			t.Errorf("%s: %v (expected %v)", e.in, actual, e.expected)
+		}
+	}
+}
+
+func TestDataDirectiveErrors(t *testing.T) {
+	for _, src := range []string{
+		".byte 256",
+		".half 0x10000",
+		".byte",
+		`.ascii "\q"`,
+		".ascii 1",
+		".bss\n.byte 1",
+		".balign 3",
+		".space -1",
+		".float x",
+	} {
+		a := assembler{}
+		if err := a.load("test.s", bytes.NewBufferString(".data\n"+src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("'%s' should be an error", src)
+		}
+	}
+}
+
+func TestAlignText(t *testing.T) {
+	a := assembleString(t, "NOP\n.align 3\nEBREAK")
+	text := a.segmentImage(true, textStartAddr)
+	nop := instToBytes(&instTypeOneReg{operation: opRPINC})
+	if len(text) != 12 || !bytes.Equal(text[4:8], nop[:]) {
+		t.Error(text)
+	}
+
+	// .text moved by .align starts after NOPs, and the entry is at it
+	a = assembleString(t, ".align 4\nEBREAK")
+	if text := a.segmentImage(true, textStartAddr); len(text) != 12 || !bytes.Equal(text[0:4], nop[:]) || !bytes.Equal(text[4:8], nop[:]) {
+		t.Error(text)
+	}
+	if a.entry != a.sections[0].addr || a.entry%16 != 0 {
+		t.Errorf("entry 0x%x", a.entry)
+	}
+
+	// .space is NOPs too, and they are instructions
+	a = assembleString(t, "NOP\n.space 8\nEBREAK")
+	if text := a.segmentImage(true, textStartAddr); len(text) != 16 || !bytes.Equal(text[8:12], nop[:]) {
+		t.Error(text)
+	}
+	if len(a.insts) != 4 || a.insts[1].text != "NOP" || a.insts[2].stmt != 1 {
+		t.Errorf("%v", a.insts)
+	}
+
+	for _, src := range []string{".byte 1\n.balign 4", ".space 2", ".space 4, 1"} {
+		a := assembler{}
+		if err := a.load("test.s", strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("'%s' should be an error", src)
+		}
+	}
+}
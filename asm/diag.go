@@ -0,0 +1,269 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// severity of a diagnostic
+type severity int
+
+const (
+	sevError severity = iota
+	sevWarning
+	sevNote
+)
+
+func (s severity) String() string {
+	return [...]string{"error", "warning", "note"}[s]
+}
+
+// codeError is the code of the errors that have no code of their own
+const codeError = "error"
+
+// fixIt is a suggested change of the source: the first old from the place of the diagnostic on replaced with new
+type fixIt struct {
+	old, new string
+}
+
+// codedError gives an error the code of its diagnostic and the fix-its
+type codedError struct {
+	code  string
+	err   error
+	fixes []fixIt
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode returns err with the code and the fix-its
+func withCode(code string, err error, fixes ...fixIt) error {
+	return &codedError{code: code, err: err, fixes: fixes}
+}
+
+// replacements returns the fix-its replacing name with the similar candidates (the ones didYouMean shows)
+func replacements(name string, candidates []string) []fixIt {
+	var fixes []fixIt
+	similar := similarNames(name, candidates)
+	for _, c := range similar[:min(len(similar), 3)] {
+		fixes = append(fixes, fixIt{old: name, new: c})
+	}
+	return fixes
+}
+
+// posError is an error at a place in the source (see lineError)
+type posError struct {
+	pos  srcPos
+	line *srcLine // nil if unknown
+	err  error
+}
+
+func (e *posError) Error() string {
+	context := ""
+	if e.line != nil {
+		context = e.line.context()
+	}
+	return fmt.Sprintf("%s: %s%s", e.pos, e.err, context)
+}
+
+func (e *posError) Unwrap() error {
+	return e.err
+}
+
+// diagnostic is an error, a warning or a note at a place in the source
+type diagnostic struct {
+	sev   severity
+	code  string
+	pos   srcPos   // the zero value if unknown (line 0 if only the file is known)
+	line  *srcLine // for the excerpt and the expansion context (nil if unknown)
+	msg   string
+	fixes []fixIt
+}
+
+// sourceText returns the source line the diagnostic is at
+func (d *diagnostic) sourceText() (string, bool) {
+	if d.line == nil || d.pos.file != d.line.pos.file {
+		return "", false
+	}
+	lines := strings.Split(d.line.text, "\n")
+	n := d.pos.line - d.line.pos.line
+	if n < 0 || n >= len(lines) || d.pos.col < 1 {
+		return "", false
+	}
+	return lines[n], true
+}
+
+// fixColumns returns the columns of the text the fix-it replaces (end is the one after it)
+func (d *diagnostic) fixColumns(f fixIt) (start, end int, ok bool) {
+	text, ok := d.sourceText()
+	if !ok || d.pos.col > len(text) {
+		return 0, 0, false
+	}
+	i := strings.Index(text[d.pos.col-1:], f.old)
+	if i < 0 {
+		return 0, 0, false
+	}
+	start = d.pos.col + i
+	return start, start + len(f.old), true
+}
+
+// excerpt returns the source line of the diagnostic and a caret under the column, or ""
+func (d *diagnostic) excerpt() string {
+	text, ok := d.sourceText()
+	if !ok {
+		return ""
+	}
+	text = strings.ReplaceAll(text, "\t", " ")
+	caret := strings.Repeat(" ", min(d.pos.col-1, len(text))) + "^"
+	return fmt.Sprintf("%5d | %s\n      | %s", d.pos.line, text, caret)
+}
+
+// String returns "file:line:col: severity: message", the excerpt and the expansion context
+func (d *diagnostic) String() string {
+	var sb strings.Builder
+	switch {
+	case d.pos.line > 0:
+		fmt.Fprintf(&sb, "%s: ", d.pos)
+	case d.pos.file != "":
+		fmt.Fprintf(&sb, "%s: ", d.pos.file)
+	}
+	fmt.Fprintf(&sb, "%s: %s", d.sev, d.msg)
+	if _, ok := warningNames[d.code]; ok {
+		if d.sev == sevError {
+			fmt.Fprintf(&sb, " [-Werror=%s]", d.code)
+		} else {
+			fmt.Fprintf(&sb, " [-W%s]", d.code)
+		}
+	}
+	if e := d.excerpt(); e != "" {
+		sb.WriteString("\n" + e)
+	}
+	if d.line != nil {
+		sb.WriteString(d.line.context())
+	}
+	return sb.String()
+}
+
+// Diagnostics collects the diagnostics of an assembly. As an error, it is the errors in it.
+type Diagnostics []diagnostic
+
+func (l Diagnostics) Error() string {
+	var msgs []string
+	for _, d := range l {
+		switch {
+		case d.sev != sevError:
+		case d.pos.file == "":
+			msgs = append(msgs, d.msg)
+		case d.pos.line == 0:
+			msgs = append(msgs, d.pos.file+": "+d.msg)
+		default:
+			msgs = append(msgs, (&posError{pos: d.pos, line: d.line, err: errors.New(d.msg)}).Error())
+		}
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Errors returns the number of the errors
+func (l Diagnostics) Errors() int {
+	n := 0
+	for _, d := range l {
+		if d.sev == sevError {
+			n++
+		}
+	}
+	return n
+}
+
+// AddError appends err as errors (the diagnostics of it when it is a Diagnostics)
+func (l *Diagnostics) AddError(err error) {
+	l.add(sevError, err)
+}
+
+// add appends err as diagnostics: the ones of a Diagnostics, of errors.Join, or one for any other error
+func (l *Diagnostics) add(sev severity, err error) {
+	var dl Diagnostics
+	var pe *posError
+	switch {
+	case errors.As(err, &dl):
+		*l = append(*l, dl...)
+	case isJoined(err):
+		for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+			l.add(sev, e)
+		}
+	case errors.As(err, &pe):
+		code, fixes := errorCode(err)
+		*l = append(*l, diagnostic{sev: sev, code: code, pos: pe.pos, line: pe.line, msg: pe.err.Error(), fixes: fixes})
+	default:
+		code, fixes := errorCode(err)
+		*l = append(*l, diagnostic{sev: sev, code: code, msg: err.Error(), fixes: fixes})
+	}
+}
+
+// note appends a note at pos
+func (l *Diagnostics) note(code string, pos srcPos, format string, args ...any) {
+	*l = append(*l, diagnostic{sev: sevNote, code: code, pos: pos, msg: fmt.Sprintf(format, args...)})
+}
+
+// errorCode returns the code of err and its fix-its
+func errorCode(err error) (string, []fixIt) {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code, ce.fixes
+	}
+	return codeError, nil
+}
+
+// isJoined reports whether err is made by errors.Join
+func isJoined(err error) bool {
+	_, ok := err.(interface{ Unwrap() []error })
+	return ok
+}
+
+// directiveNames returns all the directives (including the ones of the preprocessor), sorted
+func directiveNames() []string {
+	names := []string{".macro", ".endm", ".rept", ".irp", ".endr", ".if", ".ifdef", ".ifndef", ".elseif", ".else", ".endif", ".error", ".include"}
+	for name := range directives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mnemonics returns all the instructions and the pseudo-instructions, sorted
+func mnemonics() []string {
+	set := map[string]bool{}
+	for name := range strToSBOperation {
+		set[name] = true
+	}
+	for name := range strToMacOperation {
+		set[name] = true
+	}
+	for name := range strToOneRegOperation {
+		set[name] = true
+	}
+	for name := range strToTwoRegOperation {
+		set[name] = true
+	}
+	for name := range strToNoRegOperation {
+		set[name] = true
+	}
+	for name := range strToFloatOperation {
+		set[name] = true
+	}
+	for name := range pseudoInsts {
+		set[name] = true
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,136 @@
+package asm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// diagnose assembles src and returns the diagnostics
+func diagnose(src string) Diagnostics {
+	var diags Diagnostics
+	a := assembler{}
+	err := a.load("test.s", strings.NewReader(src))
+	if err == nil {
+		err = a.run()
+	}
+	if err != nil {
+		diags.add(sevError, err)
+	}
+	return diags
+}
+
+func TestDiagnostics(t *testing.T) {
+	diags := diagnose("NOP\nADDD.64 1 2\n  .byte 1000\nJ nowhere\n")
+	if len(diags) != 3 {
+		t.Fatalf("%d diagnostics: %v", len(diags), diags)
+	}
+	var table = []struct {
+		line, col int
+		msg       string
+	}{
+		{2, 1, "unknown instruction 'ADDD.64' (did you mean ADD.64"},
+		{3, 3, "does not fit in 8 bit immediate"},
+		{4, 1, "undefined symbol 'nowhere'"},
+	}
+	for i, e := range table {
+		d := diags[i]
+		if d.pos.line != e.line || d.pos.col != e.col || !strings.Contains(d.msg, e.msg) {
+			t.Errorf("%d: %s:%d:%d: %s (expected %d:%d: %s)", i, d.pos.file, d.pos.line, d.pos.col, d.msg, e.line, e.col, e.msg)
+		}
+	}
+	if s := diags[1].String(); !strings.HasSuffix(s, "    3 |   .byte 1000\n      |   ^") {
+		t.Errorf("excerpt:\n%s", s)
+	}
+
+	// the errors in the expansion of a macro point at the line in it
+	diags = diagnose(".macro m\nNOP\nFOO 1\n.endm\nm\n")
+	if len(diags) != 1 || diags[0].pos.line != 3 || !strings.Contains(diags[0].String(), "in expansion of macro 'm' at test.s:5:1") {
+		t.Errorf("%v", diags)
+	}
+
+	// the errors of the preprocessor are all collected, with the ones of the operands
+	diags = diagnose(".error \"a\"\n.macro m a\n.endm\nm 1, 2\n.if x\nNOP\n.endif\n.if 1\n.else\n.else\n.endif\n" +
+		".include \"none.s\"\n.rept 2\n.error \"b\"\n.endr\nADD.64 [1], 1\n")
+	lines := []int{}
+	for _, d := range diags {
+		lines = append(lines, d.pos.line)
+	}
+	if fmt.Sprint(lines) != "[1 4 5 10 12 14 14 16]" {
+		t.Errorf("%v\n%v", lines, diags)
+	}
+
+	// a recursive macro shows the innermost expansions and the outermost one
+	diags = diagnose(".macro m\nm\n.endm\nm\n")
+	if s := diags[0].String(); len(diags) != 1 || strings.Count(s, "\n\t") != maxContext+1 ||
+		!strings.Contains(s, "more expansions)") || !strings.HasSuffix(s, "in expansion of macro 'm' at test.s:4:1") {
+		t.Errorf("%v", diags)
+	}
+}
+
+func TestNoPanic(t *testing.T) {
+	for _, src := range []string{
+		"(", "ADD.64", "FADD.d 1 2 3 4 5", "LD.64 (", "%x =", ".space 0x7fffffffffffffff",
+		".rept 1000000000\n.endr", ".rept 1000\n.rept 1000\n.rept 1000\n.endr\n.endr\n.endr",
+		".macro m\nm\n.endm\nm", ".section", ".byte", "J", "BEQ 1", "l: l: NOP",
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%q: panic: %v", src, r)
+				}
+			}()
+			if diags := diagnose(src); diags.Errors() == 0 {
+				t.Errorf("%q should be an error", src)
+			}
+		}()
+	}
+}
+
+func TestDiagnosticsFormats(t *testing.T) {
+	diags := diagnose("NOP\n  ADDD.64 1 2\n%x = LUi 1\nRMOV %y\n")
+	var sb strings.Builder
+	if err := writeDiagnosticsJSON(&sb, diags); err != nil {
+		t.Fatal(err)
+	}
+	var records []diagRecord
+	if err := json.Unmarshal([]byte(sb.String()), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d records:\n%s", len(records), sb.String())
+	}
+	r := records[0]
+	if r.Severity != "error" || r.Code != "unknown-instruction" || r.File != "test.s" || r.Line != 2 || r.Column != 3 ||
+		len(r.Fixes) == 0 || r.Fixes[0] != (fixRecord{Line: 2, Column: 3, EndColumn: 10, Text: "ADD.64"}) {
+		t.Errorf("%+v", r)
+	}
+	if r := records[1]; r.Code != "undefined-value" || len(r.Fixes) != 1 || r.Fixes[0] != (fixRecord{Line: 4, Column: 6, EndColumn: 8, Text: "%x"}) {
+		t.Errorf("%+v", r)
+	}
+
+	sb.Reset()
+	if err := diags.WriteFormat(&sb, "sarif"); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal([]byte(sb.String()), &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Runs) != 1 || len(log.Runs[0].Results) != 2 || len(log.Runs[0].Tool.Driver.Rules) != 2 {
+		t.Fatalf("%s", sb.String())
+	}
+	res := log.Runs[0].Results[0]
+	if res.RuleID != "unknown-instruction" || res.Level != "error" || res.Locations[0].PhysicalLocation.Region.StartLine != 2 ||
+		res.Fixes[0].ArtifactChanges[0].Replacements[0].InsertedContent.Text != "ADD.64" {
+		t.Errorf("%+v", res)
+	}
+
+	if names := DiagnosticsFormats(); fmt.Sprint(names) != "[json sarif text]" {
+		t.Errorf("%v", names)
+	}
+	if err := diags.WriteFormat(&sb, "xml"); err == nil {
+		t.Error("xml should be an error")
+	}
+}
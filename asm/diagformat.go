@@ -0,0 +1,186 @@
+package asm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// diagnosticsFormats : the ways to write the diagnostics (-diagnostics-format)
+var diagnosticsFormats = map[string]func(w io.Writer, diags Diagnostics) error{
+	"text":  writeDiagnosticsText,
+	"json":  writeDiagnosticsJSON,
+	"sarif": writeDiagnosticsSARIF,
+}
+
+// DiagnosticsFormats returns the names of the formats of the diagnostics, sorted
+func DiagnosticsFormats() []string {
+	names := make([]string, 0, len(diagnosticsFormats))
+	for name := range diagnosticsFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteFormat writes the diagnostics to w in the format (one of DiagnosticsFormats)
+func (l Diagnostics) WriteFormat(w io.Writer, format string) error {
+	write, ok := diagnosticsFormats[format]
+	if !ok {
+		return fmt.Errorf("unknown diagnostics format '%s'", format)
+	}
+	return write(w, l)
+}
+
+func writeDiagnosticsText(w io.Writer, diags Diagnostics) error {
+	for _, d := range diags {
+		if _, err := fmt.Fprintln(w, d.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diagRecord is a diagnostic in -diagnostics-format=json
+type diagRecord struct {
+	Severity string      `json:"severity"`
+	Code     string      `json:"code"`
+	File     string      `json:"file,omitempty"`
+	Line     int         `json:"line,omitempty"`
+	Column   int         `json:"column,omitempty"`
+	Message  string      `json:"message"`
+	Context  []string    `json:"context,omitempty"` // the expansions the line comes from
+	Fixes    []fixRecord `json:"fixes,omitempty"`
+}
+
+// fixRecord replaces the columns [column, endColumn) of the line with text
+type fixRecord struct {
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndColumn int    `json:"endColumn"`
+	Text      string `json:"text"`
+}
+
+func (d *diagnostic) record() diagRecord {
+	r := diagRecord{Severity: d.sev.String(), Code: d.code, File: d.pos.file, Line: d.pos.line, Column: d.pos.col, Message: d.msg}
+	if d.line != nil {
+		r.Context = d.line.expansions()
+	}
+	for _, f := range d.fixes {
+		if start, end, ok := d.fixColumns(f); ok {
+			r.Fixes = append(r.Fixes, fixRecord{Line: d.pos.line, Column: start, EndColumn: end, Text: f.new})
+		}
+	}
+	return r
+}
+
+func writeDiagnosticsJSON(w io.Writer, diags Diagnostics) error {
+	records := make([]diagRecord, len(diags))
+	for i := range diags {
+		records[i] = diags[i].record()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// SARIF 2.1.0 (only what the diagnostics need)
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name  string      `json:"name"`
+		Rules []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID string `json:"id"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+		Fixes     []sarifFix      `json:"fixes,omitempty"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           *sarifRegion          `json:"region,omitempty"`
+	}
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+		EndColumn   int `json:"endColumn,omitempty"`
+	}
+	sarifFix struct {
+		Description     sarifMessage          `json:"description"`
+		ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+	}
+	sarifArtifactChange struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Replacements     []sarifReplacement    `json:"replacements"`
+	}
+	sarifReplacement struct {
+		DeletedRegion   sarifRegion  `json:"deletedRegion"`
+		InsertedContent sarifMessage `json:"insertedContent"`
+	}
+)
+
+func writeDiagnosticsSARIF(w io.Writer, diags Diagnostics) error {
+	run := sarifRun{Tool: sarifTool{Driver: sarifDriver{Name: "sasm2", Rules: []sarifRule{}}}, Results: []sarifResult{}}
+	rules := map[string]bool{}
+	for i := range diags {
+		d := &diags[i]
+		if !rules[d.code] {
+			rules[d.code] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.code})
+		}
+		r := d.record()
+		res := sarifResult{RuleID: r.Code, Level: r.Severity, Message: sarifMessage{Text: r.Message}}
+		for _, c := range r.Context {
+			res.Message.Text += "\n" + c
+		}
+		if r.File != "" {
+			loc := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: r.File}}
+			if r.Line > 0 {
+				loc.Region = &sarifRegion{StartLine: r.Line, StartColumn: r.Column}
+			}
+			res.Locations = []sarifLocation{{loc}}
+		}
+		for _, f := range r.Fixes {
+			res.Fixes = append(res.Fixes, sarifFix{
+				Description: sarifMessage{Text: fmt.Sprintf("replace with '%s'", f.Text)},
+				ArtifactChanges: []sarifArtifactChange{{
+					ArtifactLocation: sarifArtifactLocation{URI: r.File},
+					Replacements: []sarifReplacement{{
+						DeletedRegion:   sarifRegion{StartLine: f.Line, StartColumn: f.Column, EndColumn: f.EndColumn},
+						InsertedContent: sarifMessage{Text: f.Text},
+					}},
+				}},
+			})
+		}
+		run.Results = append(run.Results, res)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{Version: "2.1.0", Schema: "https://json.schemastore.org/sarif-2.1.0.json", Runs: []sarifRun{run}})
+}
@@ -0,0 +1,383 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exprValue is the value of a constant expression.
+// addr is set when the value is an address (it depends on a label or the location counter '.')
+type exprValue struct {
+	val  int64
+	addr bool
+}
+
+// lookup resolves a symbol used in an expression
+func (env *asmEnv) lookup(name string) (exprValue, bool) {
+	if env == nil {
+		return exprValue{}, false
+	}
+	if c, ok := env.consts[name]; ok {
+		return c.val, true
+	}
+	if addr, ok := env.symbols[name]; ok {
+		return exprValue{val: int64(addr), addr: true}, true
+	}
+	if c, ok := env.prevConsts[name]; ok {
+		return c.val, true
+	}
+	return exprValue{}, false
+}
+
+// exprParser is a recursive descent parser for constant expressions.
+// The operators and their precedence follow C:
+//
+//	unary - + ~ !
+//	* / %
+//	+ -
+//	<< >>
+//	< <= > >=
+//	== !=
+//	&
+//	^
+//	|
+//	&&
+//	||
+//
+// Primaries are integer literals (decimal, 0x, 0o, 0b and 'c'), symbols, the location counter '.',
+// parenthesized expressions and the operators %hi(expr) and %lo(expr).
+// %pcrel_hi(expr) and %pcrel_lo(expr) split expr - (the address of an AUiPC) in the same way:
+// %pcrel_hi is used by the AUiPC itself and %pcrel_lo by the instruction right after it.
+type exprParser struct {
+	src string
+	pos int
+	env *asmEnv
+}
+
+// evalExpr evaluates the constant expression s
+func evalExpr(s string, env *asmEnv) (exprValue, error) {
+	p := exprParser{src: s, env: env}
+	v, err := p.parseBinary(0)
+	if err == nil {
+		p.skipSpace()
+		if p.pos < len(p.src) {
+			err = fmt.Errorf("unexpected '%s'", p.src[p.pos:])
+		}
+	}
+	if err != nil {
+		return exprValue{}, fmt.Errorf("invalid expression '%s': %w", s, err)
+	}
+	return v, nil
+}
+
+type immSign int
+
+const (
+	immSigned   immSign = iota // -2^(bits-1) <= imm < 2^(bits-1)
+	immUnsigned                // 0 <= imm < 2^bits
+	immAny                     // -2^(bits-1) <= imm < 2^bits
+)
+
+// parseImm evaluates s and checks that it fits in a bits-wide immediate field
+func parseImm(s string, bits uint, sign immSign, env *asmEnv) (uint32, error) {
+	v, err := evalExpr(s, env)
+	if err != nil {
+		return 0, err
+	}
+	return checkImm(s, v.val, bits, sign)
+}
+
+func checkImm(s string, v int64, bits uint, sign immSign) (uint32, error) {
+	min, max := -int64(1)<<(bits-1), int64(1)<<bits-1
+	switch sign {
+	case immSigned:
+		max = int64(1)<<(bits-1) - 1
+	case immUnsigned:
+		min = 0
+	}
+	if v < min || max < v {
+		return 0, withCode("immediate-range", fmt.Errorf("'%s' = %d does not fit in %d bit immediate [%d, %d]", s, v, bits, min, max))
+	}
+	return uint32(v) & (1<<bits - 1), nil
+}
+
+// hi20 and lo12 split v so that (hi20 << 12) + sign-extended lo12 == v (LUi + ADDi)
+func hi20(v int64) int64 {
+	return ((v + 0x800) >> 12) & 0xfffff
+}
+
+func lo12(v int64) int64 {
+	return (v&0xfff ^ 0x800) - 0x800
+}
+
+var binaryOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// accept consumes op if the input continues with it
+func (p *exprParser) accept(op string) bool {
+	p.skipSpace()
+	if !strings.HasPrefix(p.src[p.pos:], op) {
+		return false
+	}
+	// don't take '<' of '<<', '|' of '||' and so on
+	if rest := p.src[p.pos+len(op):]; len(op) == 1 && strings.Contains("<>|&", op) && rest != "" && rest[0] == op[0] {
+		return false
+	}
+	p.pos += len(op)
+	return true
+}
+
+func (p *exprParser) parseBinary(level int) (exprValue, error) {
+	if level == len(binaryOperators) {
+		return p.parseUnary()
+	}
+	lhs, err := p.parseBinary(level + 1)
+	if err != nil {
+		return lhs, err
+	}
+	for {
+		op := ""
+		for _, o := range binaryOperators[level] {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return lhs, nil
+		}
+		rhs, err := p.parseBinary(level + 1)
+		if err != nil {
+			return rhs, err
+		}
+		lhs, err = applyBinary(op, lhs, rhs)
+		if err != nil {
+			return lhs, err
+		}
+	}
+}
+
+func b2i(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func applyBinary(op string, l, r exprValue) (exprValue, error) {
+	a, b := l.val, r.val
+	switch op {
+	case "+":
+		return exprValue{a + b, l.addr != r.addr}, nil
+	case "-":
+		// address - address is a plain number
+		return exprValue{a - b, l.addr && !r.addr}, nil
+	case "*":
+		return exprValue{val: a * b}, nil
+	case "/", "%":
+		if b == 0 {
+			return exprValue{}, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			return exprValue{val: a / b}, nil
+		}
+		return exprValue{val: a % b}, nil
+	case "<<":
+		return exprValue{val: a << uint64(b&63)}, nil
+	case ">>":
+		return exprValue{val: a >> uint64(b&63)}, nil
+	case "&":
+		return exprValue{val: a & b}, nil
+	case "^":
+		return exprValue{val: a ^ b}, nil
+	case "|":
+		return exprValue{val: a | b}, nil
+	case "==":
+		return exprValue{val: b2i(a == b)}, nil
+	case "!=":
+		return exprValue{val: b2i(a != b)}, nil
+	case "<":
+		return exprValue{val: b2i(a < b)}, nil
+	case "<=":
+		return exprValue{val: b2i(a <= b)}, nil
+	case ">":
+		return exprValue{val: b2i(a > b)}, nil
+	case ">=":
+		return exprValue{val: b2i(a >= b)}, nil
+	case "&&":
+		return exprValue{val: b2i(a != 0 && b != 0)}, nil
+	case "||":
+		return exprValue{val: b2i(a != 0 || b != 0)}, nil
+	}
+	return exprValue{}, fmt.Errorf("unknown operator '%s'", op)
+}
+
+func (p *exprParser) parseUnary() (exprValue, error) {
+	switch {
+	case p.accept("-"):
+		v, err := p.parseUnary()
+		return exprValue{val: -v.val}, err
+	case p.accept("+"):
+		return p.parseUnary()
+	case p.accept("~"):
+		v, err := p.parseUnary()
+		return exprValue{val: ^v.val}, err
+	case p.accept("!"):
+		v, err := p.parseUnary()
+		return exprValue{val: b2i(v.val == 0)}, err
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parseParen() (exprValue, error) {
+	if !p.accept("(") {
+		return exprValue{}, fmt.Errorf("'(' is expected")
+	}
+	v, err := p.parseBinary(0)
+	if err != nil {
+		return v, err
+	}
+	if !p.accept(")") {
+		return v, fmt.Errorf("missing ')'")
+	}
+	return v, nil
+}
+
+func isSymbolChar(c byte, first bool) bool {
+	return c == '_' || c == '.' || c == '$' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
+}
+
+func (p *exprParser) parsePrimary() (exprValue, error) {
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return exprValue{}, fmt.Errorf("unexpected end of expression")
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '(':
+		return p.parseParen()
+
+	case c == '%':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && isSymbolChar(p.src[p.pos], false) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		v, err := p.parseParen()
+		if err != nil {
+			return v, fmt.Errorf("%w after %s", err, name)
+		}
+		switch name {
+		case "%hi":
+			return exprValue{val: hi20(v.val)}, nil
+		case "%lo":
+			return exprValue{val: lo12(v.val)}, nil
+		case "%pcrel_hi", "%pcrel_lo":
+			if p.env == nil {
+				return v, fmt.Errorf("%s is not available here", name)
+			}
+			if name == "%pcrel_lo" {
+				// relative to the AUiPC right before
+				return exprValue{val: lo12(v.val - int64(p.env.pc-instSize))}, nil
+			}
+			off := v.val - int64(p.env.pc)
+			if hi := (off + 0x800) >> 12; hi < -1<<19 || hi >= 1<<19 {
+				return v, fmt.Errorf("PC-relative offset %d (0x%x from 0x%x) is out of range of AUiPC", off, v.val, p.env.pc)
+			}
+			return exprValue{val: hi20(off)}, nil
+		}
+		return v, fmt.Errorf("unknown operator %s", name)
+
+	case c == '\'':
+		r, n, err := unquoteChar(p.src[p.pos:])
+		if err != nil {
+			return exprValue{}, err
+		}
+		p.pos += n
+		return exprValue{val: int64(r)}, nil
+
+	case '0' <= c && c <= '9':
+		start := p.pos
+		for p.pos < len(p.src) && isSymbolChar(p.src[p.pos], false) {
+			p.pos++
+		}
+		return parseIntLiteral(p.src[start:p.pos])
+
+	case isSymbolChar(c, true):
+		start := p.pos
+		for p.pos < len(p.src) && isSymbolChar(p.src[p.pos], false) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		if name == "." {
+			if p.env == nil {
+				return exprValue{}, fmt.Errorf("'.' is not available here")
+			}
+			return exprValue{val: int64(p.env.pc), addr: true}, nil
+		}
+		v, ok := p.env.lookup(name)
+		if !ok {
+			return v, withCode("undefined-symbol", fmt.Errorf("undefined symbol '%s'", name))
+		}
+		return v, nil
+	}
+	return exprValue{}, fmt.Errorf("unexpected '%c'", c)
+}
+
+func parseIntLiteral(s string) (exprValue, error) {
+	base, digits := 10, s
+	if len(s) > 2 && s[0] == '0' {
+		switch s[1] {
+		case 'x', 'X':
+			base, digits = 16, s[2:]
+		case 'o', 'O':
+			base, digits = 8, s[2:]
+		case 'b', 'B':
+			base, digits = 2, s[2:]
+		}
+	}
+	v, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return exprValue{}, fmt.Errorf("invalid number '%s'", s)
+	}
+	return exprValue{val: int64(v)}, nil
+}
+
+// unquoteChar parses a character literal at the beginning of s ('A', '\n', '\x41', ...)
+// and returns its value and length
+func unquoteChar(s string) (rune, int, error) {
+	end := 1
+	for end < len(s) && s[end] != '\'' {
+		if s[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(s) {
+		return 0, 0, fmt.Errorf("unterminated character literal %s", s)
+	}
+	lit := s[:end+1]
+	r, _, tail, err := strconv.UnquoteChar(lit[1:end], '\'')
+	if err != nil || tail != "" {
+		return 0, 0, fmt.Errorf("invalid character literal %s", lit)
+	}
+	return r, len(lit), nil
+}
@@ -0,0 +1,80 @@
+package asm
+
+import (
+	"testing"
+)
+
+func TestEvalExpr(t *testing.T) {
+	env := &asmEnv{
+		pc:      0x1000,
+		symbols: symbolTable{"loop": 0x0ff8, "data": 0x12345678},
+	}
+
+	var table = []struct {
+		in       string
+		expected exprValue
+	}{
+		{"10", exprValue{val: 10}},
+		{"0x7ff", exprValue{val: 0x7ff}},
+		{"-0x800", exprValue{val: -0x800}},
+		{"0b1010", exprValue{val: 10}},
+		{"0o17", exprValue{val: 15}},
+		{"'A'", exprValue{val: 65}},
+		{"'\\n'", exprValue{val: 10}},
+		{"(4*8)+1", exprValue{val: 33}},
+		{"1<<11", exprValue{val: 2048}},
+		{"1 + 2 * 3", exprValue{val: 7}},
+		{"~0 & 0xff", exprValue{val: 0xff}},
+		{"1 < 2 && 3 >= 3", exprValue{val: 1}},
+		{"-7 / 2", exprValue{val: -3}},
+		{"loop", exprValue{val: 0x0ff8, addr: true}},
+		{"loop + 4", exprValue{val: 0x0ffc, addr: true}},
+		{". - loop", exprValue{val: 8}},
+		{"%hi(data)", exprValue{val: 0x12345}},
+		{"%lo(data)", exprValue{val: 0x678}},
+		{"%hi(0x12345fff)", exprValue{val: 0x12346}},
+		{"%lo(0x12345fff)", exprValue{val: -1}},
+	}
+	for _, e := range table {
+		actual, err := evalExpr(e.in, env)
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+		if actual != e.expected {
+			t.Error(e.in, actual, e.expected)
+		}
+	}
+
+	for _, s := range []string{"(1 + 2", "1 +", "undefined", "1 / 0", "0x", "'AB'", "%foo(1)"} {
+		if _, err := evalExpr(s, env); err == nil {
+			t.Errorf("'%s' should be an error", s)
+		}
+	}
+}
+
+func TestParseImm(t *testing.T) {
+	var table = []struct {
+		in       string
+		bits     uint
+		sign     immSign
+		expected uint32
+		ok       bool
+	}{
+		{"0x7ff", 12, immSigned, 0x7ff, true},
+		{"0x800", 12, immSigned, 0, false},
+		{"-0x800", 12, immSigned, 0x800, true},
+		{"0xfff", 12, immUnsigned, 0xfff, true},
+		{"-1", 12, immUnsigned, 0, false},
+		{"-1", 20, immAny, 0xfffff, true},
+		{"0xfffff", 20, immAny, 0xfffff, true},
+	}
+	for _, e := range table {
+		actual, err := parseImm(e.in, e.bits, e.sign, nil)
+		if (err == nil) != e.ok {
+			t.Error(e.in, err)
+		} else if actual != e.expected {
+			t.Error(e.in, actual, e.expected)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package asm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findFile looks for name relative to the directory of the file from, then in dirs (-I)
+func findFile(name, from string, dirs []string) (string, error) {
+	if filepath.IsAbs(name) {
+		if _, err := os.Stat(name); err != nil {
+			return "", fmt.Errorf("can't find '%s'", name)
+		}
+		return name, nil
+	}
+	searched := append([]string{filepath.Dir(from)}, dirs...)
+	for _, dir := range searched {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("can't find '%s' (searched: %s)", name, strings.Join(searched, ", "))
+}
+
+// fileOperand unquotes the file name operand of .include and .incbin
+func fileOperand(op []token) (string, error) {
+	if len(op) != 1 || op[0].kind != tokString {
+		return "", fmt.Errorf("the file name must be a string literal: %s", joinTokens(op))
+	}
+	return strconv.Unquote(op[0].text)
+}
+
+// include expands
+//
+//	.include "file"
+//
+// with the lines of the file
+func (p *preprocessor) include(l *srcLine, ops []token, depth int) error {
+	p.keepLabels(l, ops)
+
+	args := splitTokensByComma(ops)
+	if len(args) != 1 {
+		return lineError(l, l.pos, fmt.Errorf(`usage: .include "file"`))
+	}
+	name, err := fileOperand(args[0])
+	if err != nil {
+		return lineError(l, l.pos, err)
+	}
+	path, err := findFile(name, l.pos.file, p.includeDirs)
+	if err != nil {
+		return lineError(l, l.pos, err)
+	}
+	for _, f := range p.files {
+		if filepath.Clean(f) == filepath.Clean(path) {
+			return lineError(l, l.pos, fmt.Errorf("include cycle: %s -> %s", strings.Join(p.files, " -> "), path))
+		}
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return lineError(l, l.pos, err)
+	}
+	defer fp.Close()
+	lines, err := lexFile(path, fp)
+	if err != nil {
+		return lineError(l, l.pos, err)
+	}
+	e := &expansion{what: "included", invocation: l}
+	for i := range lines {
+		lines[i].expansion = e
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	p.files = append(p.files, path)
+	defer func() { p.files = p.files[:len(p.files)-1] }()
+	return p.process(lines, depth+1)
+}
+
+// dirIncbin handles
+//
+//	.incbin "file"[, offset[, length]]
+//
+// which emits the bytes of the file
+func (a *assembler) dirIncbin(st *statement) error {
+	if len(st.operands) < 1 || len(st.operands) > 3 {
+		return fmt.Errorf(`usage: .incbin "file"[, offset[, length]]`)
+	}
+	name, err := fileOperand(st.operands[0])
+	if err != nil {
+		return err
+	}
+	path, err := findFile(name, st.line.pos.file, a.opts.IncludeDirs)
+	if err != nil {
+		return err
+	}
+	bs, ok := a.files[path]
+	if !ok {
+		if bs, err = os.ReadFile(path); err != nil {
+			return err
+		}
+		if a.files == nil {
+			a.files = map[string][]byte{}
+		}
+		a.files[path] = bs
+	}
+
+	ops := st.operandTexts()
+	offset, length := int64(0), int64(len(bs))
+	if len(ops) >= 2 {
+		if offset, err = a.constOperand(ops[1]); err != nil {
+			return err
+		}
+		if offset < 0 || offset > int64(len(bs)) {
+			return fmt.Errorf("offset %d is out of '%s' (%d bytes)", offset, name, len(bs))
+		}
+		length -= offset
+	}
+	if len(ops) == 3 {
+		n, err := a.constOperand(ops[2])
+		if err != nil {
+			return err
+		}
+		if n < 0 || offset+n > int64(len(bs)) {
+			return fmt.Errorf("length %d at offset %d is out of '%s' (%d bytes)", n, offset, name, len(bs))
+		}
+		length = n
+	}
+	if a.cur.nobits && length > 0 {
+		return fmt.Errorf(".incbin in @nobits section %s", a.cur.name)
+	}
+	a.cur.emit(bs[offset : offset+length])
+	return nil
+}
@@ -0,0 +1,85 @@
+package asm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, s := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.s":       ".include \"sub/a.s\"\nstart: .include \"b.s\"\n.data\nbin: .incbin \"data.bin\", 1, 2\n",
+		"sub/a.s":      ".macro twice x\n\\x\n\\x\n.endm\n",
+		"inc/b.s":      "twice NOP\n",
+		"inc/data.bin": "\x01\x02\x03\x04",
+	})
+	path := filepath.Join(dir, "main.s")
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+
+	a := assembler{opts: Options{IncludeDirs: []string{filepath.Join(dir, "inc")}}}
+	if err := a.load(path, fp); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(); err != nil {
+		t.Fatal(err)
+	}
+	if a.symbols["start"] != textStartAddr {
+		t.Errorf("start: 0x%x", a.symbols["start"])
+	}
+	if a.sections[0].size != 8 {
+		t.Errorf(".text: %d bytes", a.sections[0].size)
+	}
+	if d := a.sections[1].data; string(d) != "\x02\x03" {
+		t.Errorf(".data: %v", d)
+	}
+}
+
+func TestIncludeError(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.s":       ".include \"b.s\"\n",
+		"b.s":       "NOP\n.include \"a.s\"\n",
+		"missing.s": ".include \"nothing.s\"\n",
+		"bad.s":     ".include \"bad2.s\"\n",
+		"bad2.s":    "NOP\n.endm\n",
+	})
+	var table = []struct {
+		file string
+		msg  string
+	}{
+		{"a.s", "include cycle: "},
+		{"missing.s", "can't find 'nothing.s' (searched: "},
+		{"bad.s", "bad2.s:2:1: unexpected .endm\n\tincluded at "},
+	}
+	for _, e := range table {
+		path := filepath.Join(dir, e.file)
+		fp, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := assembler{}
+		err = a.load(path, fp)
+		fp.Close()
+		if err == nil || !strings.Contains(err.Error(), e.msg) {
+			t.Errorf("%s: %v", e.file, err)
+		}
+	}
+}
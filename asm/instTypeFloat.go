@@ -1,9 +1,8 @@
-package main
+package asm
 
 import (
 	"fmt"
 	"strconv"
-	"strings"
 )
 
 type floatOperation uint32 // 8 bit OPCODE
@@ -135,12 +134,11 @@ func (i *instTypeFloat) toUInt32() uint32 {
 // fromStringToInstTypeFloat
 // example: "FMADD.s 1 2 RDN" (RM is optional)
 func fromStringToInstTypeFloat(str string) (*instTypeFloat, error) {
-	ss := strings.Fields(str)
+	ss := splitFields(str)
 	i := instTypeFloat{}
 
 	op, ok := strToFloatOperation[ss[0]]
 	if !ok {
-		println(strToFloatOperation)
 		return nil, fmt.Errorf("not found '%s' in strToFloatOperation :'%s'", ss[0], str)
 	}
 	i.operation = op
@@ -154,9 +152,9 @@ func fromStringToInstTypeFloat(str string) (*instTypeFloat, error) {
 			}
 
 			for j := 0; j < 2; j++ {
-				t, err := strconv.ParseUint(ss[j+1], 10, 8)
+				t, err := strconv.ParseUint(ss[j+1], 10, 7)
 				if err != nil {
-					return nil, fmt.Errorf("failed to ParseUint '%s' in %s: %s", ss[j+1], str, err)
+					return nil, fmt.Errorf("failed to ParseUint '%s' in %s: %w", ss[j+1], str, err)
 				}
 				i.srcRegs[j] = uint32(t)
 			}
@@ -164,7 +162,7 @@ func fromStringToInstTypeFloat(str string) (*instTypeFloat, error) {
 			if len(ss) >= 4 {
 				rm, err := fromStringToRM(ss[3])
 				if err != nil {
-					return nil, fmt.Errorf("failed to fromStringToRM '%s' in %s: %s", ss[4], str, err)
+					return nil, fmt.Errorf("failed to fromStringToRM '%s' in %s: %w", ss[3], str, err)
 				}
 				i.rm = rm
 			}
@@ -179,14 +177,14 @@ func fromStringToInstTypeFloat(str string) (*instTypeFloat, error) {
 				return nil, fmt.Errorf("invalid inst : few args '%s'", str)
 			}
 
-			t, err := strconv.ParseUint(ss[1], 10, 8)
+			t, err := strconv.ParseUint(ss[1], 10, 7)
 			if err != nil {
-				return nil, fmt.Errorf("failed to ParseUint '%s' in %s: %s", ss[1], str, err)
+				return nil, fmt.Errorf("failed to ParseUint '%s' in %s: %w", ss[1], str, err)
 			}
 			i.srcRegs[0] = uint32(t)
 		}
 	default:
-		panic("can't reach here... instTypeFloat.go switch")
+		return nil, fmt.Errorf("unsupported Float operation %d: '%s'", op, str)
 	}
 	return &i, nil
 }
@@ -1,9 +1,8 @@
-package main
+package asm
 
 import (
 	"fmt"
 	"strconv"
-	"strings"
 )
 
 type macOperation uint32 // 8 bit OPCODE
@@ -42,12 +41,11 @@ func (i *instTypeMAC) toUInt32() uint32 {
 // fromStringToInstTypeMAC
 // example: "FMADD.s 1 2 3 RDN" (RM is optional)
 func fromStringToInstTypeMAC(str string) (*instTypeMAC, error) {
-	ss := strings.Fields(str)
+	ss := splitFields(str)
 	i := instTypeMAC{}
 
 	op, ok := strToMacOperation[ss[0]]
 	if !ok {
-		println(strToMacOperation)
 		return nil, fmt.Errorf("not found '%s' in strToMacOperation :'%s'", ss[0], str)
 	}
 	i.operation = op
@@ -57,9 +55,9 @@ func fromStringToInstTypeMAC(str string) (*instTypeMAC, error) {
 	}
 
 	for j := 0; j < 3; j++ {
-		t, err := strconv.ParseUint(ss[j+1], 10, 8)
+		t, err := strconv.ParseUint(ss[j+1], 10, 7)
 		if err != nil {
-			return nil, fmt.Errorf("failed to ParseUint '%s' in %s: %s", ss[j+1], str, err)
+			return nil, fmt.Errorf("failed to ParseUint '%s' in %s: %w", ss[j+1], str, err)
 		}
 		i.srcRegs[j] = uint32(t)
 	}
@@ -67,7 +65,7 @@ func fromStringToInstTypeMAC(str string) (*instTypeMAC, error) {
 	if len(ss) >= 5 {
 		rm, err := fromStringToRM(ss[4])
 		if err != nil {
-			return nil, fmt.Errorf("failed to fromStringToRM '%s' in %s: %s", ss[4], str, err)
+			return nil, fmt.Errorf("failed to fromStringToRM '%s' in %s: %w", ss[4], str, err)
 		}
 		i.rm = rm
 	}
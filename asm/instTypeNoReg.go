@@ -1,9 +1,7 @@
-package main
+package asm
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
 )
 
 type noRegOperation uint32
@@ -35,22 +33,40 @@ func (i *instTypeNoReg) toUInt32() uint32 {
 	return uint32(i.operation) | (i.imm20 << 12)
 }
 
-func fromStringToInstTypeNoReg(str string) (*instTypeNoReg, error) {
-	ss := strings.Fields(str)
+// fromStringToInstTypeNoReg
+// example: "LUi 100", "JAL func" (the target of J/JAL is an immediate or a label)
+func fromStringToInstTypeNoReg(str string, env *asmEnv) (*instTypeNoReg, error) {
+	ss := splitFields(str)
 	i := instTypeNoReg{}
 
 	op, ok := strToNoRegOperation[ss[0]]
 	if !ok {
-		println(strToNoRegOperation)
 		return nil, fmt.Errorf("not found '%s' in strToNoRegOperation :'%s'", ss[0], str)
 	}
 	i.operation = op
 
-	t, err := strconv.ParseInt(ss[1], 10, 20)
+	if len(ss) < 2 {
+		return nil, fmt.Errorf("invalid inst : few args '%s'", str)
+	}
+
+	if op == opJ || op == opJAL {
+		imm, err := branchImm(ss[1], 20, env)
+		if err != nil {
+			return nil, fmt.Errorf("%w in %s", err, str)
+		}
+		i.imm20 = imm
+		return &i, nil
+	}
+
+	sign := immAny // upper immediates: "LUi 0xfffff" or "LUi -1"
+	if op == opSPADDi {
+		sign = immSigned
+	}
+	imm, err := parseImm(ss[1], 20, sign, env)
 	if err != nil {
-		return nil, fmt.Errorf("failed to ParseUint '%s' in %s: %s", ss[1], str, err)
+		return nil, fmt.Errorf("%w in %s", err, str)
 	}
-	i.imm20 = uint32(t) & 0xfffff
+	i.imm20 = imm
 
 	return &i, nil
 }
@@ -0,0 +1,66 @@
+package asm
+
+import (
+	"testing"
+)
+
+func TestFromStringToInstTypeNoReg(t *testing.T) {
+	compare := func(s string, expected instTypeNoReg) {
+		actual, err := fromStringToInstTypeNoReg(s, nil)
+		if err != nil {
+			t.Error(err.Error())
+		}
+
+		if *actual != expected {
+			t.Error(actual, expected)
+		}
+
+	}
+
+	var table = []struct {
+		in       string
+		expected instTypeNoReg
+	}{
+		{
+			"J 100",
+			instTypeNoReg{
+				operation: opJ,
+				imm20:     100,
+			},
+		},
+		{
+			"SPADDi -100",
+			instTypeNoReg{
+				operation: opSPADDi,
+				imm20:     0xfffff + 1 - 100,
+			},
+		},
+	}
+	for _, e := range table {
+		compare(e.in, e.expected)
+	}
+
+}
+
+func TestFromStringToInstTypeNoRegLabel(t *testing.T) {
+	env := &asmEnv{
+		pc: textStartAddr + 400,
+		symbols: symbolTable{
+			"func": textStartAddr,
+			"far":  textStartAddr + 400 + (1<<19)*instSize,
+		},
+	}
+
+	actual, err := fromStringToInstTypeNoReg("JAL func", env)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := instTypeNoReg{operation: opJAL, imm20: 0xfffff + 1 - 100}
+	if *actual != expected {
+		t.Error(actual, expected)
+	}
+
+	if _, err := fromStringToInstTypeNoReg("J far", env); err == nil {
+		t.Error("'J far' should be out of range")
+	}
+}
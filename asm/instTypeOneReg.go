@@ -1,9 +1,8 @@
-package main
+package asm
 
 import (
 	"fmt"
 	"strconv"
-	"strings"
 )
 
 type oneRegOperation uint32 // 13 (= 7 + 3 + 3) bit OPCODE
@@ -140,24 +139,27 @@ func (i *instTypeOneReg) toUInt32() uint32 {
 	return uint32(op) | (i.imm12 << 13) | (i.srcReg << 25)
 }
 
-func fromStringToInstTypeOneReg(str string) (*instTypeOneReg, error) {
-	ss := strings.Fields(str)
+func fromStringToInstTypeOneReg(str string, env *asmEnv) (*instTypeOneReg, error) {
+	ss := splitFields(str)
 	i := instTypeOneReg{}
 
 	op, ok := strToOneRegOperation[ss[0]]
 	if !ok {
-		return nil, fmt.Errorf("not found '%s' in strToOneRegOperation(%v) :'%s'", ss[0], strToOneRegOperation, str)
+		return nil, fmt.Errorf("not found '%s' in strToOneRegOperation :'%s'", ss[0], str)
 	}
 	i.operation = op
 
 	switch op {
 	case opRPINC:
 		if ss[0] == "RPINC" {
-			t, err := strconv.ParseUint(ss[1], 10, 7)
+			if len(ss) < 2 {
+				return nil, fmt.Errorf("too few arg: %s", str)
+			}
+			t, err := parseImm(ss[1], 7, immUnsigned, env)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s' opRPINC): %s", ss[1], str, err)
+				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s' opRPINC): %w", ss[1], str, err)
 			}
-			i.srcReg = uint32(t)
+			i.srcReg = t
 		} // else -> NOP
 
 	case opFENCE: // FENCE succ(4bit) pred(4bit)
@@ -165,15 +167,15 @@ func fromStringToInstTypeOneReg(str string) (*instTypeOneReg, error) {
 			if len(ss) < 3 {
 				return nil, fmt.Errorf("'FENCE succ pred'")
 			}
-			succ, err := strconv.ParseUint(ss[1], 10, 4)
+			succ, err := parseImm(ss[1], 4, immUnsigned, env)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s' opFENCE): %s", ss[1], str, err)
+				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s' opFENCE): %w", ss[1], str, err)
 			}
-			pred, err := strconv.ParseUint(ss[2], 10, 4)
+			pred, err := parseImm(ss[2], 4, immUnsigned, env)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s' opFENCE): %s", ss[2], str, err)
+				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s' opFENCE): %w", ss[2], str, err)
 			}
-			i.imm12 = uint32(pred | (succ << 4))
+			i.imm12 = pred | (succ << 4)
 		}
 
 	case opFENCEI:
@@ -195,27 +197,42 @@ func fromStringToInstTypeOneReg(str string) (*instTypeOneReg, error) {
 			if len(ss) < 3 {
 				return nil, fmt.Errorf("too few arg: %s", str)
 			}
-			srcReg1, err := strconv.ParseUint(ss[1], 10, 7) // srcReg1 or zImm
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s'): %s", ss[1], str, err)
-			}
-			i.srcReg = uint32(srcReg1)
-			imm, err := strconv.ParseInt(ss[2], 10, 12) // Imm or CSR
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s'): %s", ss[2], str, err)
+			if op == opCSRRWi || op == opCSRRSi || op == opCSRRCi {
+				zimm, err := parseImm(ss[1], 7, immUnsigned, env) // zImm
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s'): %w", ss[1], str, err)
+				}
+				i.srcReg = zimm
+			} else {
+				srcReg1, err := strconv.ParseUint(ss[1], 10, 7) // srcReg1
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s'): %w", ss[1], str, err)
+				}
+				i.srcReg = uint32(srcReg1)
 			}
 
 			if isShift(op) {
-				if imm != imm&0x3f {
-					return nil, fmt.Errorf("invalid immediate %d (Shift operation's imm: 6bit)", imm)
+				imm, err := parseImm(ss[2], 6, immUnsigned, env) // Shift operation's imm: 6bit
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s'): %w", ss[2], str, err)
 				}
 				var funct uint32
 				if ss[0] == "SRAi.32" || ss[0] == "SRAi.64" {
 					funct = 1 << 3
 				}
-				i.imm12 = uint32(imm&0x3f)<<5 | funct
+				i.imm12 = imm<<5 | funct
+			} else if isCSR(op) {
+				csr, err := csrNumber(ss[2], env)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s'): %w", ss[2], str, err)
+				}
+				i.imm12 = csr
 			} else {
-				i.imm12 = uint32(imm) & 0xfff
+				imm, err := parseImm(ss[2], 12, immSignOf(op), env) // Imm
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s'): %w", ss[2], str, err)
+				}
+				i.imm12 = imm
 			}
 		}
 
@@ -225,11 +242,11 @@ func fromStringToInstTypeOneReg(str string) (*instTypeOneReg, error) {
 			if len(ss) < 2 {
 				return nil, fmt.Errorf("too few arg: %s", str)
 			}
-			imm, err := strconv.ParseInt(ss[1], 10, 12) // Imm
+			imm, err := parseImm(ss[1], 12, immSigned, env) // Imm
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s' opSPLD): %s", ss[1], str, err)
+				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s' opSPLD): %w", ss[1], str, err)
 			}
-			i.imm12 = uint32(imm & (1<<12 - 1))
+			i.imm12 = imm
 		}
 
 	case opRMOV:
@@ -239,13 +256,12 @@ func fromStringToInstTypeOneReg(str string) (*instTypeOneReg, error) {
 			}
 			srcReg1, err := strconv.ParseUint(ss[1], 10, 7) // srcReg1 or zImm
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s'): %s", ss[1], str, err)
+				return nil, fmt.Errorf("failed to parse %s (OneReg instruction '%s'): %w", ss[1], str, err)
 			}
 			i.srcReg = uint32(srcReg1)
 		}
 	default:
-		println("op ", op)
-		panic("can't reach here...exhaustive switch in fromStringToInstTypeOneReg")
+		return nil, fmt.Errorf("unsupported OneReg operation %d: '%s'", op, str)
 	}
 	return &i, nil
 }
@@ -258,3 +274,24 @@ func isShift(op oneRegOperation) bool {
 		return false
 	}
 }
+
+func isCSR(op oneRegOperation) bool {
+	switch op {
+	case opCSRRW, opCSRRS, opCSRRC, opCSRRWi, opCSRRSi, opCSRRCi:
+		return true
+	default:
+		return false
+	}
+}
+
+// immSignOf returns how the 12bit immediate of op is interpreted
+func immSignOf(op oneRegOperation) immSign {
+	switch op {
+	case opCSRRW, opCSRRS, opCSRRC, opCSRRWi, opCSRRSi, opCSRRCi:
+		return immUnsigned // CSR number
+	case opXORi32, opORi32, opANDi32, opSLTiu32, opXORi64, opORi64, opANDi64, opSLTiu64:
+		return immAny // bit patterns: "ANDi.64 1 0xfff" == "ANDi.64 1 -1"
+	default:
+		return immSigned
+	}
+}
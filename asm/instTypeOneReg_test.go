@@ -1,12 +1,13 @@
-package main
+package asm
 
 import (
+	"strings"
 	"testing"
 )
 
 func TestFromStringToInstTypeOneReg(t *testing.T) {
 	compare := func(s string, expected instTypeOneReg) {
-		actual, err := fromStringToInstTypeOneReg(s)
+		actual, err := fromStringToInstTypeOneReg(s, nil)
 		if err != nil {
 			t.Error(err.Error())
 		}
@@ -74,3 +75,34 @@ func TestFromStringToInstTypeOneReg(t *testing.T) {
 		compare(e.in, e.expected)
 	}
 }
+
+func TestFromStringToInstTypeOneRegCSR(t *testing.T) {
+	var table = []struct {
+		in       string
+		expected instTypeOneReg
+	}{
+		{"CSRRS 0 cycle", instTypeOneReg{operation: opCSRRS, imm12: 0xc00, srcReg: 0}},
+		{"CSRRW 3 0x300", instTypeOneReg{operation: opCSRRW, imm12: 0x300, srcReg: 3}},
+		{"CSRRSi 5 mstatus", instTypeOneReg{operation: opCSRRSi, imm12: 0x300, srcReg: 5}},
+	}
+	for _, e := range table {
+		actual, err := fromStringToInstTypeOneReg(e.in, nil)
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+		if *actual != e.expected {
+			t.Error(e.in, actual, e.expected)
+		}
+	}
+
+	_, err := fromStringToInstTypeOneReg("CSRRS 0 cylce", nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown CSR 'cylce' (did you mean cycle") {
+		t.Error(err)
+	}
+	for _, s := range []string{"CSRRS 0 0x1000", "CSRRW 1"} {
+		if _, err := fromStringToInstTypeOneReg(s, nil); err == nil {
+			t.Errorf("'%s' should be an error", s)
+		}
+	}
+}
@@ -1,9 +1,8 @@
-package main
+package asm
 
 import (
 	"fmt"
 	"strconv"
-	"strings"
 )
 
 type sbOperation uint32
@@ -44,13 +43,23 @@ func (i *instTypeSB) toUInt32() uint32 {
 	return uint32(i.operation) | (i.imm12 << 6) | (i.srcRegs[1] << 18) | (i.srcRegs[0] << 25)
 }
 
-func fromStringToInstTypeSB(str string) (*instTypeSB, error) {
-	ss := strings.Fields(str)
+func isBranch(op sbOperation) bool {
+	switch op {
+	case opBLT, opBGE, opBLTU, opBGEU, opBEQ, opBNE:
+		return true
+	default:
+		return false
+	}
+}
+
+// fromStringToInstTypeSB
+// example: "ST.8 1 2 -10", "BNE 1 2 loop" (the target of a branch is an immediate or a label)
+func fromStringToInstTypeSB(str string, env *asmEnv) (*instTypeSB, error) {
+	ss := splitFields(str)
 	i := instTypeSB{}
 
 	op, ok := strToSBOperation[ss[0]]
 	if !ok {
-		println(strToSBOperation)
 		return nil, fmt.Errorf("not found '%s' in strToSBOperation :'%s'", ss[0], str)
 	}
 	i.operation = op
@@ -60,18 +69,27 @@ func fromStringToInstTypeSB(str string) (*instTypeSB, error) {
 	}
 
 	for j := 0; j < 2; j++ {
-		t, err := strconv.ParseUint(ss[j+1], 10, 8)
+		t, err := strconv.ParseUint(ss[j+1], 10, 7)
 		if err != nil {
-			return nil, fmt.Errorf("failed to ParseUint '%s' in %s: %s", ss[j+1], str, err)
+			return nil, fmt.Errorf("failed to ParseUint '%s' in %s: %w", ss[j+1], str, err)
 		}
 		i.srcRegs[j] = uint32(t)
 	}
 
-	t, err := strconv.ParseInt(ss[3], 10, 12)
+	if isBranch(op) {
+		imm, err := branchImm(ss[3], 12, env)
+		if err != nil {
+			return nil, fmt.Errorf("%w in %s", err, str)
+		}
+		i.imm12 = imm
+		return &i, nil
+	}
+
+	imm, err := parseImm(ss[3], 12, immSigned, env)
 	if err != nil {
-		return nil, fmt.Errorf("failed to ParseUint '%s' in %s: %s", ss[3], str, err)
+		return nil, fmt.Errorf("%w in %s", err, str)
 	}
-	i.imm12 = uint32(t) & 0xfff
+	i.imm12 = imm
 
 	return &i, nil
 }
@@ -0,0 +1,86 @@
+package asm
+
+import (
+	"testing"
+)
+
+func TestFromStringToInstTypeSB(t *testing.T) {
+	compare := func(s string, expected instTypeSB) {
+		actual, err := fromStringToInstTypeSB(s, nil)
+		if err != nil {
+			t.Error(err.Error())
+		}
+
+		if *actual != expected {
+			t.Error(actual, expected)
+		}
+
+	}
+
+	var table = []struct {
+		in       string
+		expected instTypeSB
+	}{
+		{
+			"ST.8 1 2 -10 ",
+			instTypeSB{
+				operation: opST8,
+				imm12:     0xffffffff - 9,
+				srcRegs:   [2]uint32{1, 2},
+			},
+		},
+	}
+	for _, e := range table {
+		compare(e.in, e.expected)
+	}
+
+}
+
+func TestFromStringToInstTypeSBLabel(t *testing.T) {
+	env := &asmEnv{
+		pc: textStartAddr + 40,
+		symbols: symbolTable{
+			"loop": textStartAddr + 8,
+			"next": textStartAddr + 44,
+			"far":  textStartAddr + 40 + 2048*instSize,
+		},
+	}
+
+	var table = []struct {
+		in       string
+		expected instTypeSB
+	}{
+		{
+			"BNE 1 2 loop",
+			instTypeSB{
+				operation: opBNE,
+				imm12:     0xfff + 1 - 8,
+				srcRegs:   [2]uint32{1, 2},
+			},
+		},
+		{
+			"BEQ 3 4 next",
+			instTypeSB{
+				operation: opBEQ,
+				imm12:     1,
+				srcRegs:   [2]uint32{3, 4},
+			},
+		},
+	}
+	for _, e := range table {
+		actual, err := fromStringToInstTypeSB(e.in, env)
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+		if *actual != e.expected {
+			t.Error(actual, e.expected)
+		}
+	}
+
+	for _, s := range []string{"BLT 1 2 far", "BGE 1 2 undefined", "ST.64 1 200 0"} {
+		if _, err := fromStringToInstTypeSB(s, env); err == nil {
+			t.Errorf("'%s' should be an error", s)
+		}
+	}
+}
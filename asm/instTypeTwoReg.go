@@ -1,9 +1,8 @@
-package main
+package asm
 
 import (
 	"fmt"
 	"strconv"
-	"strings"
 )
 
 type twoRegOperation uint32 // 18 (= 7 + 1 + 3 + 2 + 5) bit OPCODE
@@ -96,7 +95,7 @@ func (i *instTypeTwoReg) toUInt32() uint32 {
 }
 
 func fromStringToInstTypeTwoReg(str string) (*instTypeTwoReg, error) {
-	ss := strings.Fields(str)
+	ss := splitFields(str)
 	i := instTypeTwoReg{}
 
 	if len(ss) < 3 {
@@ -105,7 +104,7 @@ func fromStringToInstTypeTwoReg(str string) (*instTypeTwoReg, error) {
 
 	op, ok := strToTwoRegOperation[ss[0]]
 	if !ok {
-		return nil, fmt.Errorf("not found '%s' in strToTwoRegOperation(%v) :'%s'", ss[0], strToTwoRegOperation, str)
+		return nil, fmt.Errorf("not found '%s' in strToTwoRegOperation :'%s'", ss[0], str)
 	}
 	i.operation = op
 
@@ -1,4 +1,4 @@
-package main
+package asm
 
 import (
 	"fmt"
@@ -46,6 +46,6 @@ func fromStringToRM(s string) (roundmode, error) {
 	case "Dynamic":
 		return rmDynamic, nil
 	default:
-		return rmRNE, fmt.Errorf("invalid Rounding Mode: " + s)
+		return rmRNE, fmt.Errorf("invalid Rounding Mode: %s", s)
 	}
 }
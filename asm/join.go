@@ -0,0 +1,295 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// padding counts the instructions inserted for a join
+type padding struct {
+	label       string
+	nops, rmovs int
+}
+
+// joinEdge is a way into a join: a branch or a jump to the label, or the fall-through from the line before
+type joinEdge struct {
+	stmt int    // the branch or the jump, or the statement of the label for the fall-through
+	end  uint64 // the address right after the last instruction of the edge
+	fall bool
+}
+
+// joinEdges returns the ways into the statement s by its label (not the calls)
+func (a *assembler) joinEdges(s int, label string) []joinEdge {
+	var edges []joinEdge
+	for j, st := range a.stmts {
+		if st.mnemonic == nil || a.placed[j].end == a.placed[j].start {
+			continue
+		}
+		k := branchOffsetOperand(st)
+		if k < 0 || k >= len(st.operands) || st.mnemonic.text == "JAL" {
+			continue
+		}
+		if op := st.operands[k]; len(op) == 1 && op[0].kind == tokIdent && op[0].text == label {
+			edges = append(edges, joinEdge{stmt: j, end: a.placed[j].end})
+		}
+	}
+	if len(edges) == 0 {
+		return nil
+	}
+	for k := s - 1; k >= 0; k-- {
+		p := a.placed[k]
+		if p.end == p.start {
+			continue
+		}
+		st := a.stmts[k]
+		if p.sec == a.placed[s].sec && p.end == a.placed[s].start && !unconditionalJumps[st.mnemonic.text] {
+			edges = append([]joinEdge{{stmt: s, end: a.placed[s].start, fall: true}}, edges...)
+		}
+		break
+	}
+	return edges
+}
+
+// liveValues returns the named values used from the statement s on before they are produced there
+// (following the fall-throughs up to an unconditional jump), sorted
+func (a *assembler) liveValues(s int) []string {
+	produced := map[string]bool{}
+	live := map[string]bool{}
+	for k := s; k < len(a.stmts); k++ {
+		st := a.stmts[k]
+		if k > s && a.placed[k].sec != a.placed[s].sec {
+			break
+		}
+		for _, op := range st.operands {
+			if name, ok := valueRef(op); ok && !isHidden(name) && !produced[name] {
+				live[name] = true
+			}
+		}
+		if a.placed[k].end > a.placed[k].start {
+			for _, name := range valueNames(st) {
+				produced[name] = true
+			}
+			if unconditionalJumps[st.mnemonic.text] {
+				break
+			}
+		}
+	}
+	names := make([]string, 0, len(live))
+	for name := range live {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// valueNames returns the names of the value the statement produces
+func valueNames(st *statement) []string {
+	var names []string
+	if st.name != nil {
+		names = append(names, st.name.text)
+	}
+	for _, l := range st.labels {
+		names = append(names, l.text)
+	}
+	return names
+}
+
+// edgeDistance returns the distance to the value at the end of the edge, following the straight-line path back.
+// self is true when the path reaches the join itself (s) with the value not produced in between.
+func (a *assembler) edgeDistance(e joinEdge, s int, name string) (d int, found, self bool) {
+	from := e.stmt
+	if e.fall {
+		from = s - 1
+	}
+	for k := from; k >= 0; k-- {
+		p := a.placed[k]
+		if p.sec != a.placed[s].sec {
+			return 0, false, false
+		}
+		if p.end > p.start {
+			for _, n := range valueNames(a.stmts[k]) {
+				if n == name {
+					return int((e.end - (p.end - instSize)) / instSize), true, false
+				}
+			}
+		}
+		if k == s {
+			return 0, false, true
+		}
+		if d, ok := a.joinValues[a.stmts[k]][name]; ok {
+			return d + int((e.end-p.start)/instSize), true, false
+		}
+		if a.breaksPath(k) {
+			return 0, false, false
+		}
+	}
+	return 0, false, false
+}
+
+// padJoins makes the named values used after each branch target be at the same distance on all the paths
+// into it: NOPs on the paths where they are nearer if that is enough and cheaper (and not before
+// a conditional branch), otherwise "RMOV %value" for each of them at the end of every path. It returns the number of the instructions inserted.
+func (a *assembler) padJoins() (int, error) {
+	pinned := a.pinned()
+	type insertion struct {
+		at    int
+		stmts []*statement
+	}
+	var insertions []insertion
+	for s, st := range a.stmts {
+		if len(st.labels) == 0 || a.placed[s].end == a.placed[s].start {
+			continue
+		}
+		label := st.labels[0].text
+		edges := a.joinEdges(s, label)
+		names := a.liveValues(s)
+		if len(edges) == 0 || len(names) == 0 {
+			continue
+		}
+
+		// dist[e][v]: the distance to the value v at the end of the edge e
+		dist := make([][]int, len(edges))
+		complete, relay := true, false
+		for i, e := range edges {
+			dist[i] = make([]int, len(names))
+			for j, name := range names {
+				d, found, self := a.edgeDistance(e, s, name)
+				switch {
+				case found:
+					dist[i][j] = d
+				case self:
+					complete = false
+				default:
+					return 0, lineError(a.stmts[e.stmt].line, a.stmts[e.stmt].pos(),
+						fmt.Errorf("%%%s is not produced on this path into label '%s'", name, label))
+				}
+			}
+		}
+		// the NOPs: every value of an edge is the same number of instructions nearer than in the furthest one
+		var nops []int
+		if complete {
+			nops = make([]int, len(edges))
+			offset := func(i int) int { return dist[i][0] - dist[0][0] }
+			most := 0
+			for i := range edges {
+				most = max(most, offset(i))
+			}
+			for i := range edges {
+				for j := range names {
+					if dist[i][j]-dist[0][j] != offset(i) {
+						relay = true
+					}
+				}
+				nops[i] = most - offset(i)
+				if nops[i] > 0 && !edges[i].fall && !unconditionalJumps[a.stmts[edges[i].stmt].mnemonic.text] {
+					relay = true // NOPs before a conditional branch would be on the fall-through too
+				}
+			}
+		}
+		inserted, rmovs := 0, len(names)*len(edges)+1
+		for _, n := range nops {
+			inserted += n
+		}
+		if !complete || relay || inserted > rmovs {
+			nops, relay = nil, true
+		}
+
+		values := map[string]int{}
+		for j, name := range names {
+			if relay {
+				values[name] = len(names) - j + 1
+			} else {
+				values[name] = dist[0][j] + nops[0]
+			}
+		}
+		a.joinValues[st] = values
+		if !relay && inserted == 0 {
+			continue
+		}
+
+		pad := a.padding(label)
+		for i, e := range edges {
+			var stmts []*statement
+			pos := a.stmts[e.stmt].pos()
+			if relay {
+				for _, name := range names {
+					stmts = append(stmts, a.newInst(e.stmt, pos, "RMOV", &token{kind: tokIdent, text: name, pos: pos},
+						[]token{{kind: tokPunct, text: "%", pos: pos}, {kind: tokIdent, text: name, pos: pos}}))
+				}
+				pad.rmovs += len(names)
+				if e.fall {
+					stmts = append(stmts, a.newInst(e.stmt, pos, "NOP", nil))
+					pad.nops++
+				}
+			} else {
+				for n := 0; n < nops[i]; n++ {
+					stmts = append(stmts, a.newInst(e.stmt, pos, "NOP", nil))
+				}
+				pad.nops += nops[i]
+			}
+			if len(stmts) == 0 {
+				continue
+			}
+			if pinned[e.stmt] {
+				return 0, lineError(a.stmts[e.stmt].line, pos,
+					fmt.Errorf("can't pad the path into label '%s' here: a numeric distance or branch offset goes over it", label))
+			}
+			if !e.fall {
+				// the labels on the branch come to the padding
+				br := a.stmts[e.stmt]
+				stmts[0].labels, stmts[0].entry, br.labels, br.entry = br.labels, br.entry, nil, false
+			}
+			insertions = append(insertions, insertion{e.stmt, stmts})
+		}
+	}
+
+	sort.SliceStable(insertions, func(i, j int) bool { return insertions[i].at > insertions[j].at })
+	n := 0
+	for _, ins := range insertions {
+		a.stmts = append(a.stmts[:ins.at], append(ins.stmts, a.stmts[ins.at:]...)...)
+		n += len(ins.stmts)
+	}
+	return n, nil
+}
+
+// newInst returns an instruction statement inserted at the line of the statement at
+func (a *assembler) newInst(at int, pos srcPos, mnemonic string, name *token, operands ...[]token) *statement {
+	return &statement{
+		line:     a.stmts[at].line,
+		inserted: true,
+		name:     name,
+		mnemonic: &token{kind: tokIdent, text: mnemonic, pos: pos},
+		operands: operands,
+	}
+}
+
+// padding returns the count of the instructions inserted for the label
+func (a *assembler) padding(label string) *padding {
+	for _, p := range a.paddings {
+		if p.label == label {
+			return p
+		}
+	}
+	p := &padding{label: label}
+	a.paddings = append(a.paddings, p)
+	return p
+}
+
+// labelPos returns the place of the label
+func (a *assembler) labelPos(label string) srcPos {
+	for _, st := range a.stmts {
+		for _, l := range st.labels {
+			if l.text == label {
+				return l.pos
+			}
+		}
+	}
+	return srcPos{}
+}
+
+// defineJoinValues defines the values that are at the same distance on all the paths into st
+func (a *assembler) defineJoinValues(st *statement) {
+	for name, d := range a.joinValues[st] {
+		a.values[name] = valueDef{stmt: a.stmtNo, sec: a.cur, pc: a.cur.pc() - uint64(d)*instSize}
+	}
+}
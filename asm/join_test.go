@@ -0,0 +1,85 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+// assemblePad assembles src with the joins padded and verified
+func assemblePad(t *testing.T, src string) *assembler {
+	a := assembler{opts: Options{Pad: true, Verify: true}}
+	if err := a.load("test.s", strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(); err != nil {
+		t.Fatal(err)
+	}
+	return &a
+}
+
+func TestPadJoins(t *testing.T) {
+	// a NOP on the shorter arm
+	a := assemblePad(t, `
+%x = LUi 1
+BEQ %x, %x, else
+%y = ADDi.64 %x, 1
+%y = ADDi.64 %y, 1
+J join
+else: %y = ADDi.64 %x, 2
+join: RMOV %y
+`)
+	checkText(t, a, []string{"LUi 1", "BEQ 1, 1, else", "ADDi.64 2, 1", "ADDi.64 1, 1", "J join", "ADDi.64 2, 2", "NOP", "RMOV 2"})
+	if len(a.paddings) != 1 || *a.paddings[0] != (padding{"join", 1, 0}) {
+		t.Errorf("paddings: %v", a.paddings)
+	}
+
+	// a loop-carried value
+	a = assemblePad(t, "%i = LUi 0\nloop: %i = ADDi.64 %i, 1\nBNE %i, %i, loop\nRMOV %i\n")
+	checkText(t, a, []string{"LUi 0", "NOP", "ADDi.64 2, 1", "BNE 1, 1, loop", "RMOV 2"})
+
+	// the values are in different orders: relays
+	a = assemblePad(t, `
+%a = LUi 1
+%b = LUi 2
+BEQ %a, %b, else
+%b = ADDi.64 %b, 1
+J join
+else: %a = ADDi.64 %a, 1
+join: ADD.64 %a, %b
+`)
+	checkText(t, a, []string{"LUi 1", "LUi 2", "BEQ 2, 1, else", "ADDi.64 2, 1", "RMOV 4", "RMOV 2", "J join",
+		"ADDi.64 3, 1", "RMOV 1", "RMOV 4", "NOP", "ADD.64 3, 2"})
+	if len(a.paddings) != 1 || *a.paddings[0] != (padding{"join", 1, 4}) {
+		t.Errorf("paddings: %v", a.paddings)
+	}
+
+	// the labels on their own lines
+	a = assemblePad(t, `
+%x = LUi 1
+%y = LUi 2
+BEQ %x, %y, else
+%r = ADDi.64 %x, 10
+J join
+else:
+%r = ADDi.64 %y, 20
+join:
+RMOV %r
+`)
+	checkText(t, a, []string{"LUi 1", "LUi 2", "BEQ 2, 1, else", "ADDi.64 3, 10", "J join", "ADDi.64 2, 20", "NOP", "RMOV 2"})
+	if len(a.paddings) != 1 || *a.paddings[0] != (padding{"join", 1, 0}) {
+		t.Errorf("paddings: %v", a.paddings)
+	}
+
+	for _, src := range []string{
+		"LUi 1\nBEQ 1, 1, l\n%x = LUi 2\nl: RMOV %x",                    // not produced on the branch
+		"%x = LUi 1\nBEQ 1, 1, l\nNOP\nADD.64 3, 1\nl: RMOV %x\nRMOV 3", // the numeric distance over the padding
+	} {
+		a := assembler{opts: Options{Pad: true}}
+		if err := a.load("test.s", strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("%q should be an error", src)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// srcPos : position in a source file (line and col are 1-origin, col counts bytes)
+type srcPos struct {
+	file string
+	line int
+	col  int
+}
+
+func (p srcPos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.file, p.line, p.col)
+}
+
+type tokenKind int
+
+const (
+	tokIdent  tokenKind = iota // mnemonic, directive or symbol: "ADDi.64", ".text", "loop"
+	tokNumber                  // "10", "0x7ff", "1f"
+	tokString                  // "\"abc\\n\"" (quotes included)
+	tokChar                    // "'A'" (quotes included)
+	tokPunct                   // operators, parentheses, ',', ':' and so on
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	pos   srcPos
+	space bool // preceded by whitespace
+}
+
+// srcLine is a logical line: a physical line ending with '\' continues on the next line
+type srcLine struct {
+	pos       srcPos
+	text      string // the source text (all physical lines)
+	tokens    []token
+	expansion *expansion // nil unless the line comes from a macro or a loop
+}
+
+// multi-character operators; longer ones first
+var punctuators = []string{"<<", ">>", "<=", ">=", "==", "!=", "&&", "||"}
+
+// lexLine splits a physical line into tokens; comments (#, ; and //) are dropped.
+// Even if there is an error, all the tokens that could be read are returned.
+func lexLine(text string, pos srcPos) ([]token, error) {
+	var tokens []token
+	var err error
+	space := true
+	for i := 0; i < len(text); {
+		c := text[i]
+		tok := token{pos: pos, space: space}
+		tok.pos.col += i
+		start := i
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			space = true
+			i++
+			continue
+
+		case c == '#' || c == ';' || strings.HasPrefix(text[i:], "//"):
+			return tokens, err
+
+		case c == '"' || c == '\'':
+			i++
+			for i < len(text) && text[i] != c {
+				if text[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(text) {
+				if err == nil {
+					err = &posError{pos: tok.pos, err: withCode("syntax", fmt.Errorf("unterminated literal %s", text[start:]))}
+				}
+				i = len(text)
+			} else {
+				i++
+			}
+			tok.kind = tokChar
+			if c == '"' {
+				tok.kind = tokString
+			}
+
+		case '0' <= c && c <= '9':
+			// also floating point numbers: "1.5e-3"
+			hex := strings.HasPrefix(text[i:], "0x") || strings.HasPrefix(text[i:], "0X")
+			for i < len(text) && (isSymbolChar(text[i], false) && text[i] != '$' ||
+				!hex && (text[i] == '+' || text[i] == '-') && (text[i-1] == 'e' || text[i-1] == 'E')) {
+				i++
+			}
+			tok.kind = tokNumber
+
+		case isSymbolChar(c, true):
+			for i < len(text) && isSymbolChar(text[i], false) {
+				i++
+			}
+			tok.kind = tokIdent
+
+		default:
+			i++
+			for _, p := range punctuators {
+				if strings.HasPrefix(text[start:], p) {
+					i = start + len(p)
+					break
+				}
+			}
+			tok.kind = tokPunct
+			if c < ' ' || c > '~' {
+				if err == nil {
+					err = &posError{pos: tok.pos, err: withCode("syntax", fmt.Errorf("unexpected character %q", c))}
+				}
+				space = true
+				continue
+			}
+		}
+		tok.text = text[start:i]
+		tokens = append(tokens, tok)
+		space = false
+	}
+	return tokens, err
+}
+
+// lexFile reads a whole file into logical lines.
+// Blank lines and lines with only a comment are kept (with no tokens) so that line numbers stay intact.
+func lexFile(fileName string, r io.Reader) ([]srcLine, error) {
+	var lines []srcLine
+	var errs Diagnostics
+	scanner := bufio.NewScanner(r)
+	var cur *srcLine
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		pos := srcPos{file: fileName, line: n, col: 1}
+
+		cont := false
+		if t := strings.TrimRight(text, " \t"); strings.HasSuffix(t, "\\") {
+			cont = true
+			text = t[:len(t)-1]
+		}
+
+		tokens, err := lexLine(text, pos)
+		if err != nil {
+			if pe, ok := err.(*posError); ok {
+				pe.line = &srcLine{pos: pos, text: text}
+			}
+			errs.add(sevError, err)
+		}
+		if cur == nil {
+			lines = append(lines, srcLine{pos: pos, text: text})
+			cur = &lines[len(lines)-1]
+		} else {
+			cur.text += "\n" + text
+			if len(tokens) > 0 {
+				tokens[0].space = true
+			}
+		}
+		cur.tokens = append(cur.tokens, tokens...)
+		if !cont {
+			cur = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return lines, err
+	}
+	if len(errs) > 0 {
+		return lines, errs
+	}
+	return lines, nil
+}
+
+// joinTokens returns the text of tokens, keeping the whitespace between them as a single space
+func joinTokens(tokens []token) string {
+	var sb strings.Builder
+	for i, t := range tokens {
+		if i > 0 && t.space {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(t.text)
+	}
+	return sb.String()
+}
+
+// splitTokens groups the tokens of an operand list into operands.
+// The operands are separated by whitespace, or only by commas if there is a comma
+// (then an operand can be an expression containing spaces: "ADDi.64 1, 4 * 8").
+// Parenthesized expressions are never split.
+func splitTokens(tokens []token) [][]token {
+	depth := 0
+	for _, t := range tokens {
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				return splitTokensByComma(tokens)
+			}
+		}
+	}
+
+	var ops [][]token
+	depth = 0
+	for _, t := range tokens {
+		if len(ops) == 0 || depth == 0 && t.space {
+			ops = append(ops, nil)
+		}
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		ops[len(ops)-1] = append(ops[len(ops)-1], t)
+	}
+	return ops
+}
+
+// splitTokensByComma splits tokens at the commas outside of parentheses
+func splitTokensByComma(tokens []token) [][]token {
+	if len(tokens) == 0 {
+		return nil
+	}
+	ops := [][]token{nil}
+	depth := 0
+	for _, t := range tokens {
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				ops = append(ops, nil)
+				continue
+			}
+		}
+		ops[len(ops)-1] = append(ops[len(ops)-1], t)
+	}
+	return ops
+}
+
+func operandTexts(tokens []token) []string {
+	var ops []string
+	for _, op := range splitTokens(tokens) {
+		ops = append(ops, joinTokens(op))
+	}
+	return ops
+}
+
+// splitFields splits an instruction into the mnemonic and the operands (see splitTokens)
+func splitFields(s string) []string {
+	tokens, _ := lexLine(s, srcPos{})
+	if len(tokens) == 0 {
+		return nil
+	}
+	return append([]string{tokens[0].text}, operandTexts(tokens[1:])...)
+}
+
+// splitOperands splits a list of operands (see splitTokens)
+func splitOperands(s string) []string {
+	tokens, _ := lexLine(s, srcPos{})
+	return operandTexts(tokens)
+}
@@ -0,0 +1,111 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLexLine(t *testing.T) {
+	var table = []struct {
+		in       string
+		expected []token
+	}{
+		{
+			"loop: BNE 1, 2, loop # comment",
+			[]token{
+				{tokIdent, "loop", srcPos{"a.s", 1, 1}, true},
+				{tokPunct, ":", srcPos{"a.s", 1, 5}, false},
+				{tokIdent, "BNE", srcPos{"a.s", 1, 7}, true},
+				{tokNumber, "1", srcPos{"a.s", 1, 11}, true},
+				{tokPunct, ",", srcPos{"a.s", 1, 12}, false},
+				{tokNumber, "2", srcPos{"a.s", 1, 14}, true},
+				{tokPunct, ",", srcPos{"a.s", 1, 15}, false},
+				{tokIdent, "loop", srcPos{"a.s", 1, 17}, true},
+			},
+		},
+		{
+			"ADDi.64 1 (1<<11)-1 // comment",
+			[]token{
+				{tokIdent, "ADDi.64", srcPos{"a.s", 1, 1}, true},
+				{tokNumber, "1", srcPos{"a.s", 1, 9}, true},
+				{tokPunct, "(", srcPos{"a.s", 1, 11}, true},
+				{tokNumber, "1", srcPos{"a.s", 1, 12}, false},
+				{tokPunct, "<<", srcPos{"a.s", 1, 13}, false},
+				{tokNumber, "11", srcPos{"a.s", 1, 15}, false},
+				{tokPunct, ")", srcPos{"a.s", 1, 17}, false},
+				{tokPunct, "-", srcPos{"a.s", 1, 18}, false},
+				{tokNumber, "1", srcPos{"a.s", 1, 19}, false},
+			},
+		},
+		{
+			`.ascii "a;b#c" ; comment`,
+			[]token{
+				{tokIdent, ".ascii", srcPos{"a.s", 1, 1}, true},
+				{tokString, `"a;b#c"`, srcPos{"a.s", 1, 8}, true},
+			},
+		},
+		{
+			"  ; only a comment",
+			nil,
+		},
+	}
+	for _, e := range table {
+		actual, err := lexLine(e.in, srcPos{"a.s", 1, 1})
+		if err != nil {
+			t.Error(err.Error())
+		}
+		if len(actual) != len(e.expected) {
+			t.Error(e.in, actual, e.expected)
+			continue
+		}
+		for i := range actual {
+			if actual[i] != e.expected[i] {
+				t.Error(e.in, actual[i], e.expected[i])
+			}
+		}
+	}
+
+	if _, err := lexLine(`ADDi.64 1 'A`, srcPos{"a.s", 1, 1}); err == nil {
+		t.Error("unterminated literal should be an error")
+	}
+}
+
+func TestLexFile(t *testing.T) {
+	src := "NOP\r\n\r\nBNE 1 2 \\\r\n  loop\r\n# end\r\n"
+	lines, err := lexFile("a.s", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(lines) != 4 {
+		t.Fatal(lines)
+	}
+	if lines[2].pos.line != 3 || joinTokens(lines[2].tokens) != "BNE 1 2 loop" {
+		t.Error(lines[2])
+	}
+	if last := lines[2].tokens[3]; last.pos != (srcPos{"a.s", 4, 3}) {
+		t.Error(last)
+	}
+	if lines[3].pos.line != 5 || len(lines[3].tokens) != 0 {
+		t.Error(lines[3])
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	var table = []struct {
+		in       string
+		expected []string
+	}{
+		{"LD.8 	124 30", []string{"LD.8", "124", "30"}},
+		{"ADDi.64 1, 4 * 8", []string{"ADDi.64", "1", "4 * 8"}},
+		{"ADDi.64 1 (4 * 8)", []string{"ADDi.64", "1", "(4 * 8)"}},
+		{"ADDi.64 1 ' '", []string{"ADDi.64", "1", "' '"}},
+		{"ST.8 1 2 -10 ", []string{"ST.8", "1", "2", "-10"}},
+		{"NOP", []string{"NOP"}},
+	}
+	for _, e := range table {
+		actual := splitFields(e.in)
+		if strings.Join(actual, "|") != strings.Join(e.expected, "|") {
+			t.Error(e.in, actual, e.expected)
+		}
+	}
+}
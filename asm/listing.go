@@ -0,0 +1,200 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// listingRow is a statement in the listing under the source line it comes from
+type listingRow struct {
+	stmt  int
+	depth int // the number of the expansions (macros, loops and .include) in between
+}
+
+// rootLine returns the line of the file being assembled the line comes from and the number of the expansions in between
+func rootLine(l *srcLine) (*srcLine, int) {
+	depth := 0
+	for l.expansion != nil {
+		l = l.expansion.invocation
+		depth++
+	}
+	return l, depth
+}
+
+// isPseudo reports whether the statement is a pseudo-instruction
+func isPseudo(st *statement) bool {
+	if st.mnemonic == nil {
+		return false
+	}
+	_, ok := pseudoInsts[st.mnemonic.text]
+	return ok
+}
+
+// firstLine returns the first physical line of the text
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return strings.TrimSpace(line)
+}
+
+// shownText returns the statement with the names the assembler gave to the values (see hiddenRef)
+// replaced with the distances they are resolved to
+func shownText(st, resolved *statement) string {
+	shown := *st
+	shown.operands = append([][]token(nil), st.operands...)
+	for k, op := range st.operands {
+		if name, ok := valueRef(op); ok && isHidden(name) {
+			shown.operands[k] = resolved.operands[k]
+		}
+	}
+	return shown.String()
+}
+
+// stmtText returns the instruction of the statement i as the listing shows it
+func (a *assembler) stmtText(i int) string {
+	if text, ok := a.shown[i]; ok {
+		return text
+	}
+	return a.stmts[i].String()
+}
+
+// writeListing writes every line of the source with the addresses and the encodings of what it generates:
+//
+//	line  address   encoding  source
+//	   3  200000b0  0000a037  %x = LUi 10
+//	                            [1] 200000b0  test.s:3  %x = LUi 10    (the instruction a distance refers to)
+//
+// The expansions of the macros, the loops, the included files and the pseudo-instructions are indented under their line.
+func (a *assembler) writeListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	rows := map[int][]listingRow{} // the line number in the file -> the statements from it
+	for i, st := range a.stmts {
+		root, depth := rootLine(st.line)
+		if st.inserted {
+			depth++
+		}
+		rows[root.pos.line] = append(rows[root.pos.line], listingRow{i, depth})
+	}
+	insts := map[int][]int{} // statement -> the instructions of it
+	for n, e := range a.insts {
+		insts[e.stmt] = append(insts[e.stmt], n)
+	}
+	g := a.buildCFG()
+
+	fmt.Fprintf(bw, " line  address   encoding  source\n")
+	for _, l := range a.source {
+		// the address and the encoding go on the source line when it is a single instruction
+		addr, enc := "", ""
+		var own []int
+		rest := rows[l.pos.line]
+		if len(rest) == 1 && rest[0].depth == 0 {
+			ns := insts[rest[0].stmt]
+			switch {
+			case len(ns) == 1 && !isPseudo(a.stmts[rest[0].stmt]):
+				own, rest = ns, nil
+				addr, enc = fmt.Sprintf("%08x", a.insts[ns[0]].pc), fmt.Sprintf("%08x", a.insts[ns[0]].inst.toUInt32())
+			case len(ns) == 0:
+				addr, enc = a.dataColumns(rest[0].stmt)
+				rest = nil
+			}
+		}
+		for k, text := range strings.Split(l.text, "\n") {
+			if k == 0 {
+				fmt.Fprintf(bw, "%5d  %-8s  %-8s  %s\n", l.pos.line, addr, enc, text)
+			} else {
+				fmt.Fprintf(bw, "%5d  %-8s  %-8s  %s\n", l.pos.line+k, "", "", text)
+			}
+		}
+		for _, n := range own {
+			a.writeReferences(bw, g, n, 0)
+		}
+		for _, r := range rest {
+			a.writeStatement(bw, g, r, insts[r.stmt])
+		}
+	}
+	return bw.Flush()
+}
+
+// dataColumns returns the address and the first bytes of what the statement puts in its section, or ""
+func (a *assembler) dataColumns(i int) (string, string) {
+	p, ok := a.data[i]
+	if !ok {
+		return "", ""
+	}
+	enc := ""
+	if !p.sec.nobits {
+		bs := p.sec.data[p.start-p.sec.addr : p.end-p.sec.addr]
+		enc = fmt.Sprintf("%x", bs)
+		if len(bs) > 4 {
+			enc = enc[:6] + ".."
+		}
+	}
+	return fmt.Sprintf("%08x", p.start), enc
+}
+
+// writeStatement writes a statement expanded from a line (and the instructions of a pseudo-instruction under it)
+func (a *assembler) writeStatement(w io.Writer, g *cfg, r listingRow, ns []int) {
+	st := a.stmts[r.stmt]
+	indent := strings.Repeat("    ", r.depth)
+	text := firstLine(st.line.text)
+	if r.depth == 0 || st.inserted {
+		text = a.stmtText(r.stmt)
+	}
+	if st.inserted {
+		text += "    (inserted)"
+	}
+	if len(ns) == 1 && !isPseudo(st) {
+		e := a.insts[ns[0]]
+		fmt.Fprintf(w, "       %08x  %08x  %s%s\n", e.pc, e.inst.toUInt32(), indent, text)
+		a.writeReferences(w, g, ns[0], r.depth)
+		return
+	}
+	if r.depth > 0 {
+		addr, enc := a.dataColumns(r.stmt)
+		if len(ns) > 0 {
+			addr, enc = fmt.Sprintf("%08x", a.insts[ns[0]].pc), ""
+		}
+		if addr != "" || st.mnemonic != nil {
+			fmt.Fprintf(w, "       %-8s  %-8s  %s%s\n", addr, enc, indent, text)
+		}
+	}
+	indent += "    " // the instructions of the pseudo-instruction
+	for _, n := range ns {
+		e := a.insts[n]
+		fmt.Fprintf(w, "       %08x  %08x  %s%s\n", e.pc, e.inst.toUInt32(), indent, e.text)
+		a.writeReferences(w, g, n, r.depth+1)
+	}
+}
+
+// writeReferences writes the instructions the distances of the instruction n refer to (the ones on all the paths back)
+func (a *assembler) writeReferences(w io.Writer, g *cfg, n int, depth int) {
+	indent := strings.Repeat("    ", depth) + "  "
+	for k, d := range instDistances(g.insts[n].inst) {
+		if d == 0 || d > maxDistance {
+			continue
+		}
+		paths, _ := g.paths(n, int(d))
+		seen := map[int]bool{}
+		for _, p := range paths {
+			m := p[len(p)-1]
+			if seen[m] {
+				continue
+			}
+			seen[m] = true
+			e := g.insts[m]
+			st := a.stmts[e.stmt]
+			text := firstLine(st.line.text)
+			switch shown := a.stmtText(e.stmt); {
+			case isPseudo(st) || st.inserted && shown != e.text:
+				text = e.text + " (" + shown + ")"
+			case st.inserted:
+				text = e.text + " (inserted)"
+			}
+			fmt.Fprintf(w, "                           %s[%d] %08x  %s:%d  %s\n", indent, k+1, e.pc, st.pos().file, st.pos().line, text)
+		}
+		if len(seen) == 0 {
+			fmt.Fprintf(w, "                           %s[%d] %d back: not known here (from the entry or a call)\n", indent, k+1, d)
+		}
+	}
+}
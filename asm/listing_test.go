@@ -0,0 +1,78 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestListing(t *testing.T) {
+	a := assembleString(t, `.macro inc v
+ADDi.64 \v, 1
+.endm
+! %x = LUi 10
+inc 1
+LI.64 0x12345
+ADD.64 %x, 1
+.data
+d: .byte 1, 2, 3, 4, 5
+`)
+	var sb strings.Builder
+	if err := a.writeListing(&sb); err != nil {
+		t.Fatal(err)
+	}
+	inst := func(n int) string {
+		return fmt.Sprintf("%08x  %08x", a.insts[n].pc, a.insts[n].inst.toUInt32())
+	}
+	want := []string{
+		" line  address   encoding  source",
+		"    1                      .macro inc v",
+		"    2                      ADDi.64 \\v, 1",
+		"    3                      .endm",
+		"    4  " + inst(0) + "  ! %x = LUi 10",
+		"    5                      inc 1",
+		"       " + inst(1) + "      ADDi.64 1, 1",
+		fmt.Sprintf("                                 [1] %08x  test.s:4  ! %%x = LUi 10", a.insts[0].pc),
+		"    6                      LI.64 0x12345",
+		"       " + inst(2) + "      LUi 0x12",
+		"       " + inst(3) + "      ADDi.64 1, 837",
+		fmt.Sprintf("                                 [1] %08x  test.s:6  LUi 0x12 (LI.64 0x12345)", a.insts[2].pc),
+		"    7  " + inst(4) + "  ADD.64 %x, 1",
+		fmt.Sprintf("                             [1] %08x  test.s:4  ! %%x = LUi 10", a.insts[0].pc),
+		fmt.Sprintf("                             [2] %08x  test.s:6  ADDi.64 1, 837 (LI.64 0x12345)", a.insts[3].pc),
+		"    8                      .data",
+		fmt.Sprintf("    9  %08x  010203..  d: .byte 1, 2, 3, 4, 5", dataStartAddr),
+	}
+	got := strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if a.insts[0].pc != ProgEntryAddr+ElfHeaderSize+ElfProgHeaderSize*3 {
+		t.Errorf("the first instruction at 0x%x", a.insts[0].pc)
+	}
+}
+
+func TestListingRelays(t *testing.T) {
+	a := assembler{opts: Options{Relay: true}}
+	src := "%x = LUi 1\n.rept 130\nNOP\n.endr\nBEQZ %x, l\nADD.64 %x, 1\nl: ECALL\n"
+	if err := a.load("test.s", strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(); err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	if err := a.writeListing(&sb); err != nil {
+		t.Fatal(err)
+	}
+	// the distances to the relays, not the names the assembler gave to them
+	out := sb.String()
+	for _, want := range []string{"RMOV 127    (inserted)", "RMOV 127 (inserted)", "LUi 0 (BEQZ 5, l)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "%#") {
+		t.Errorf("hidden names in\n%s", out)
+	}
+}
@@ -0,0 +1,132 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isNumericLabel reports whether s is a numeric local label ("1")
+func isNumericLabel(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || '9' < s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isLocalLabel reports whether the label name is local: ".L" labels and numeric labels, which are renamed
+// to ".Lname$scope" and ".Ln$k" by renameLocalLabels. They are not exported.
+func isLocalLabel(name string) bool {
+	return strings.HasPrefix(name, ".L") || isNumericLabel(name)
+}
+
+// numericRef splits a reference to a numeric local label ("1b", "1f") into the label and the direction
+func numericRef(s string) (string, byte, bool) {
+	if len(s) < 2 || !isNumericLabel(s[:len(s)-1]) {
+		return "", 0, false
+	}
+	if c := s[len(s)-1]; c == 'b' || c == 'f' {
+		return s[:len(s)-1], c, true
+	}
+	return "", 0, false
+}
+
+// renameLocalLabels gives the local labels and the references to them unique names:
+//
+//	1:  ...  1b / 1f   the nearest definition of 1 backward (up to this line) / forward (after this line)
+//	.Lname             scoped to the enclosing .func or, outside of .func ... .endfunc, the last global label
+func renameLocalLabels(stmts []*statement) error {
+	// the definitions of the numeric labels: label -> indices of the statements
+	defs := map[string][]int{}
+	for i, st := range stmts {
+		for _, l := range st.labels {
+			if isNumericLabel(l.text) {
+				defs[l.text] = append(defs[l.text], i)
+			}
+		}
+	}
+	numericName := func(label string, k int) string {
+		return fmt.Sprintf(".L%s$%d", label, k)
+	}
+
+	scope, function := "", ""
+	var funcStmt *statement
+	for i, st := range stmts {
+		if st.mnemonic != nil {
+			switch st.mnemonic.text {
+			case ".func":
+				if len(st.operands) != 1 || len(st.operands[0]) != 1 || st.operands[0][0].kind != tokIdent {
+					return lineError(st.line, st.pos(), fmt.Errorf("usage: .func name"))
+				}
+				if function != "" {
+					return lineError(st.line, st.pos(), fmt.Errorf(".func in .func %s", function))
+				}
+				function, funcStmt = st.operands[0][0].text, st
+			case ".endfunc":
+				if function == "" {
+					return lineError(st.line, st.pos(), fmt.Errorf(".endfunc without .func"))
+				}
+				function = ""
+			}
+		}
+
+		for j := range st.labels {
+			l := &st.labels[j]
+			switch {
+			case isNumericLabel(l.text):
+				for k, d := range defs[l.text] {
+					if d == i {
+						l.text = numericName(l.text, k)
+						break
+					}
+				}
+			case strings.HasPrefix(l.text, ".L"):
+				l.text += "$" + scopeOf(function, scope)
+			default:
+				scope = l.text
+			}
+		}
+
+		for _, op := range st.operands {
+			for j := range op {
+				t := &op[j]
+				if label, dir, ok := numericRef(t.text); ok && t.kind == tokNumber {
+					k := -1
+					for n, d := range defs[label] {
+						if dir == 'b' && d <= i || dir == 'f' && d > i && k < 0 {
+							k = n
+						}
+					}
+					if k < 0 {
+						where := map[byte]string{'b': "before", 'f': "after"}[dir]
+						return lineError(st.line, t.pos, fmt.Errorf("no local label %s: %s this line", label, where))
+					}
+					t.kind, t.text = tokIdent, numericName(label, k)
+				} else if t.kind == tokIdent && strings.HasPrefix(t.text, ".L") {
+					t.text += "$" + scopeOf(function, scope)
+				}
+			}
+		}
+	}
+	if function != "" {
+		return lineError(funcStmt.line, funcStmt.pos(), fmt.Errorf(".func %s without .endfunc", function))
+	}
+	return nil
+}
+
+// scopeOf returns the scope of the .L labels
+func scopeOf(function, scope string) string {
+	if function != "" {
+		return function
+	}
+	return scope
+}
+
+// dirFunc handles .func name and .endfunc, which only give the scope of the .L labels (see renameLocalLabels)
+func (a *assembler) dirFunc(st *statement) error {
+	return nil
+}
@@ -0,0 +1,55 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocalLabels(t *testing.T) {
+	a := assembleString(t, `
+f:
+1:	NOP
+	BEQ 1, 2, 1f
+	J 1b
+1:	JAL 1b
+.Lloop:	BNE 1, 2, .Lloop
+g:
+.Lloop:	J .Lloop
+.func h
+.Lloop:	NOP
+i:	J .Lloop
+.endfunc
+`)
+	checkText(t, a, []string{"NOP", "BEQ 1, 2, 2", "J -2", "JAL 0", "BNE 1, 2, 0", "J 0", "NOP", "J -1"})
+	for name := range a.symbols {
+		if name != "f" && name != "g" && name != "i" && !isLocalLabel(name) {
+			t.Errorf("label %s", name)
+		}
+	}
+	for _, name := range []string{".Lloop$f", ".Lloop$g", ".Lloop$h"} {
+		if _, ok := a.symbols[name]; !ok {
+			t.Errorf("%s is not defined: %v", name, a.symbols)
+		}
+	}
+
+	var table = []struct {
+		in       string
+		expected string
+	}{
+		{"J 1b\n1: NOP", "test.s:1:3: no local label 1: before this line"},
+		{"1: J 1f", "test.s:1:6: no local label 1: after this line"},
+		{".func f\nNOP", "test.s:1:1: .func f without .endfunc"},
+		{".endfunc", "test.s:1:1: .endfunc without .func"},
+		{"f: J .Lx\ng: .Lx: NOP", "undefined symbol '.Lx$f'"},
+	}
+	for _, e := range table {
+		a := assembler{}
+		err := a.load("test.s", strings.NewReader(e.in))
+		if err == nil {
+			err = a.run()
+		}
+		if err == nil || !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%q: %v (expected %q)", e.in, err, e.expected)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxExpansionDepth : limit of nested macro expansions (to stop infinite recursion)
+const maxExpansionDepth = 100
+
+// maxExpansions : limit of the expansions in all (nested .rept and macros multiply)
+const maxExpansions = 1 << 20
+
+// maxContext : the expansions shown in the context of a line (the innermost ones and the outermost one)
+const maxContext = 8
+
+// limitError is an error that stops the preprocessing (an expansion beyond the limits).
+// The other errors are collected and the preprocessing goes on with the next line.
+type limitError struct {
+	error
+}
+
+// expansion records where a line was expanded from
+type expansion struct {
+	what       string   // "in expansion of macro 'name'", "included" and so on
+	invocation *srcLine // the line that invoked the expansion
+}
+
+type macroParam struct {
+	name       string
+	def        string
+	hasDefault bool
+}
+
+// macro is defined by
+//
+//	.macro name [param[=default]][, param[=default]]...
+//	body (\param is replaced with the argument, \@ with a number unique to the expansion)
+//	.endm
+type macro struct {
+	name   string
+	params []macroParam
+	body   []srcLine
+	def    *srcLine
+}
+
+// preprocessor expands macros, loops and included files in the source lines before they are parsed into statements
+type preprocessor struct {
+	macros      map[string]*macro
+	counter     int // \@
+	includeDirs []string
+	files       []string        // the files being read (to detect include cycles)
+	consts      constTable      // constants known before the layout (-D and .equ/.set of numbers) for .if
+	names       map[string]bool // labels and constants defined so far for .ifdef
+	expansions  int             // the expansions so far
+	out         []srcLine
+	diags       Diagnostics // the errors of the lines left out
+}
+
+func newPreprocessor(includeDirs []string, defines map[string]int64) *preprocessor {
+	p := preprocessor{
+		macros:      map[string]*macro{},
+		includeDirs: includeDirs,
+		consts:      constTable{},
+		names:       map[string]bool{},
+	}
+	for name, v := range defines {
+		p.consts[name] = constant{val: exprValue{val: v}}
+		p.names[name] = true
+	}
+	return &p
+}
+
+// expansions returns the chain of expansions the line comes from, the innermost first
+// ("in expansion of macro 'name' at file:line:col" ...). A chain longer than maxContext is cut in the middle.
+func (l *srcLine) expansions() []string {
+	var chain []string
+	for e := l.expansion; e != nil; e = e.invocation.expansion {
+		chain = append(chain, fmt.Sprintf("%s at %s", e.what, e.invocation.pos))
+	}
+	if n := len(chain); n > maxContext {
+		cut := fmt.Sprintf("... (%d more expansions)", n-maxContext)
+		chain = append(chain[:maxContext-1:maxContext-1], cut, chain[n-1])
+	}
+	return chain
+}
+
+// context returns the chain of expansions the line comes from ("\n\tin expansion of macro 'name' at file:line:col" ...)
+func (l *srcLine) context() string {
+	var sb strings.Builder
+	for _, e := range l.expansions() {
+		fmt.Fprintf(&sb, "\n\t%s", e)
+	}
+	return sb.String()
+}
+
+// lineError makes err an error at pos of the line (with its expansion context).
+// An error that already has its place is left as it is.
+func lineError(l *srcLine, pos srcPos, err error) error {
+	var pe *posError
+	if errors.As(err, &pe) {
+		return err
+	}
+	return &posError{pos: pos, line: l, err: err}
+}
+
+// directiveOf returns the mnemonic of a line (skipping labels) and its operand tokens
+func directiveOf(l *srcLine) (string, []token) {
+	st := parseStatement(l)
+	if st.mnemonic == nil {
+		return "", nil
+	}
+	for i := range l.tokens {
+		if &l.tokens[i] == st.mnemonic {
+			return st.mnemonic.text, l.tokens[i+1:]
+		}
+	}
+	return st.mnemonic.text, nil
+}
+
+// collectBlock returns the lines up to the one that ends the block started at lines[0]
+// (nested blocks opened by one of open are skipped) and the number of lines consumed
+func collectBlock(lines []srcLine, open []string, end string) ([]srcLine, int, error) {
+	depth := 1
+	for i := 1; i < len(lines); i++ {
+		d, _ := directiveOf(&lines[i])
+		for _, o := range open {
+			if d == o {
+				depth++
+			}
+		}
+		if d == end {
+			depth--
+			if depth == 0 {
+				return lines[1:i], i + 1, nil
+			}
+		}
+	}
+	d, _ := directiveOf(&lines[0])
+	return nil, 0, lineError(&lines[0], lines[0].pos, fmt.Errorf("%s without %s", d, end))
+}
+
+// report collects the error of a line. It returns the error if it stops the preprocessing.
+func (p *preprocessor) report(err error) error {
+	var le limitError
+	if errors.As(err, &le) {
+		return err
+	}
+	p.diags.add(sevError, err)
+	return nil
+}
+
+// process expands lines into p.out. The errors are collected in p.diags, except the ones that stop it (returned).
+func (p *preprocessor) process(lines []srcLine, depth int) error {
+	if depth > 0 {
+		p.expansions++
+	}
+	switch {
+	case len(lines) == 0:
+		return nil
+	case depth > maxExpansionDepth:
+		return lineError(&lines[0], lines[0].pos, limitError{fmt.Errorf("too deep expansion (> %d)", maxExpansionDepth)})
+	case p.expansions > maxExpansions:
+		return lineError(&lines[0], lines[0].pos, limitError{fmt.Errorf("too many expansions (> %d)", maxExpansions)})
+	}
+	for i := 0; i < len(lines); i++ {
+		l := &lines[i]
+		d, ops := directiveOf(l)
+		var err error
+		switch d {
+		case ".macro":
+			body, n, berr := collectBlock(lines[i:], []string{".macro"}, ".endm")
+			if berr != nil {
+				return p.report(berr) // the rest is in the block
+			}
+			if err = p.define(l, ops, body); err != nil {
+				err = lineError(l, l.pos, err)
+			}
+			i += n - 1
+
+		case ".rept", ".irp":
+			body, n, berr := collectBlock(lines[i:], []string{".rept", ".irp"}, ".endr")
+			if berr != nil {
+				return p.report(berr)
+			}
+			err = p.loop(l, d, ops, body, depth)
+			i += n - 1
+
+		case ".if", ".ifdef", ".ifndef":
+			p.keepLabels(l, ops)
+			body, n, cerr := p.conditional(lines[i:])
+			if n == 0 {
+				return p.report(cerr) // the end of the block is not known
+			}
+			if err = cerr; err == nil {
+				err = p.process(body, depth)
+			}
+			i += n - 1
+
+		case ".endm", ".endr", ".elseif", ".else", ".endif":
+			err = lineError(l, l.pos, fmt.Errorf("unexpected %s", d))
+
+		case ".error":
+			err = lineError(l, l.pos, p.userError(ops))
+
+		case ".include":
+			err = p.include(l, ops, depth)
+
+		default:
+			if m, ok := p.macros[d]; ok {
+				err = p.invoke(m, l, ops, depth)
+				break
+			}
+			p.record(l, d, ops)
+			p.out = append(p.out, *l)
+		}
+		if err != nil {
+			if err := p.report(err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *preprocessor) define(l *srcLine, ops []token, body []srcLine) error {
+	if len(ops) == 0 {
+		return fmt.Errorf("usage: .macro name [param[=default]][, param[=default]]...")
+	}
+	m := macro{name: ops[0].text, body: body, def: l}
+	if ops[0].kind != tokIdent {
+		return fmt.Errorf("invalid macro name '%s'", ops[0].text)
+	}
+	if old, ok := p.macros[m.name]; ok {
+		return fmt.Errorf("macro '%s' is already defined at %s", m.name, old.def.pos)
+	}
+	for _, op := range splitTokens(ops[1:]) {
+		if len(op) == 0 || op[0].kind != tokIdent || len(op) > 1 && op[1].text != "=" {
+			return fmt.Errorf("invalid macro parameter '%s'", joinTokens(op))
+		}
+		param := macroParam{name: op[0].text}
+		if len(op) > 1 {
+			param.def = joinTokens(op[2:])
+			param.hasDefault = true
+		}
+		for _, q := range m.params {
+			if q.name == param.name {
+				return fmt.Errorf("duplicate macro parameter '%s'", param.name)
+			}
+		}
+		m.params = append(m.params, param)
+	}
+	p.macros[m.name] = &m
+	return nil
+}
+
+// keepLabels outputs the labels (and '!') in front of the directive of l
+func (p *preprocessor) keepLabels(l *srcLine, ops []token) {
+	if n := len(l.tokens) - len(ops) - 1; n > 0 {
+		labels := *l
+		labels.tokens = l.tokens[:n]
+		p.out = append(p.out, labels)
+	}
+}
+
+func (p *preprocessor) invoke(m *macro, l *srcLine, ops []token, depth int) error {
+	p.keepLabels(l, ops)
+
+	args := map[string]string{}
+	positional := 0
+	for _, op := range splitTokens(ops) {
+		if len(op) >= 2 && op[0].kind == tokIdent && op[1].text == "=" {
+			args[op[0].text] = joinTokens(op[2:])
+			continue
+		}
+		if positional >= len(m.params) {
+			return lineError(l, op[0].pos, fmt.Errorf("too many arguments for macro '%s'", m.name))
+		}
+		args[m.params[positional].name] = joinTokens(op)
+		positional++
+	}
+	subst := map[string]string{}
+	for _, param := range m.params {
+		v, ok := args[param.name]
+		if !ok {
+			if !param.hasDefault {
+				return lineError(l, l.pos, fmt.Errorf("missing argument '%s' for macro '%s' defined at %s", param.name, m.name, m.def.pos))
+			}
+			v = param.def
+		}
+		delete(args, param.name)
+		subst[param.name] = v
+	}
+	for name := range args {
+		return lineError(l, l.pos, fmt.Errorf("macro '%s' defined at %s has no parameter '%s'", m.name, m.def.pos, name))
+	}
+
+	p.counter++
+	subst["@"] = strconv.Itoa(p.counter)
+	body, err := substitute(m.body, subst, &expansion{what: "in expansion of macro '" + m.name + "'", invocation: l})
+	if err != nil {
+		return err
+	}
+	return p.process(body, depth+1)
+}
+
+// loop expands
+//
+//	.rept count          .irp sym, value[, value]...
+//	body                 body (\sym is replaced with each value)
+//	.endr                .endr
+func (p *preprocessor) loop(l *srcLine, d string, ops []token, body []srcLine, depth int) error {
+	if d == ".rept" {
+		v, err := evalExpr(joinTokens(ops), p.env())
+		if err != nil {
+			return lineError(l, l.pos, err)
+		}
+		if v.val < 0 {
+			return lineError(l, l.pos, fmt.Errorf("negative count %d", v.val))
+		}
+		if v.val > int64(maxExpansions-p.expansions) {
+			return lineError(l, l.pos, fmt.Errorf("too many expansions (> %d)", maxExpansions))
+		}
+		for n := int64(0); n < v.val; n++ {
+			lines, err := substitute(body, nil, &expansion{what: "in expansion of .rept", invocation: l})
+			if err != nil {
+				return err
+			}
+			if err := p.process(lines, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	args := splitTokensByComma(ops)
+	if len(args) == 0 || len(args[0]) != 1 || args[0][0].kind != tokIdent {
+		return lineError(l, l.pos, fmt.Errorf("usage: .irp sym, value[, value]..."))
+	}
+	for _, v := range args[1:] {
+		lines, err := substitute(body, map[string]string{args[0][0].text: joinTokens(v)}, &expansion{what: "in expansion of .irp", invocation: l})
+		if err != nil {
+			return err
+		}
+		if err := p.process(lines, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// substitute replaces \name in the body with subst[name] and lexes the lines again
+func substitute(body []srcLine, subst map[string]string, e *expansion) ([]srcLine, error) {
+	lines := make([]srcLine, len(body))
+	for n, l := range body {
+		var sb strings.Builder
+		text := l.text
+		for i := 0; i < len(text); i++ {
+			if text[i] != '\\' || i+1 == len(text) {
+				sb.WriteByte(text[i])
+				continue
+			}
+			j := i + 1
+			for j < len(text) && isSymbolChar(text[j], false) && text[j] != '.' {
+				j++
+			}
+			if j == i+1 && text[j] == '@' {
+				j++
+			}
+			if v, ok := subst[text[i+1:j]]; ok {
+				sb.WriteString(v)
+				i = j - 1
+			} else if strings.HasPrefix(text[i:], "\\()") {
+				i += 2 // separator: "\name\()suffix"
+			} else {
+				sb.WriteByte(text[i])
+			}
+		}
+
+		lines[n] = srcLine{pos: l.pos, text: sb.String(), expansion: e}
+		for k, t := range strings.Split(lines[n].text, "\n") {
+			pos := l.pos
+			pos.line += k
+			tokens, err := lexLine(t, pos)
+			if err != nil {
+				return nil, fmt.Errorf("%w%s", err, lines[n].context())
+			}
+			if k > 0 && len(tokens) > 0 {
+				tokens[0].space = true
+			}
+			lines[n].tokens = append(lines[n].tokens, tokens...)
+		}
+	}
+	return lines, nil
+}
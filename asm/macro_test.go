@@ -0,0 +1,113 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+// preprocessString returns the statements (in the canonical form) that src expands to
+func preprocessString(src string) (string, error) {
+	lines, err := lexFile("test.s", strings.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+	pp := newPreprocessor(nil, nil)
+	if err := pp.process(lines, 0); err != nil {
+		return "", err
+	}
+	if pp.diags.Errors() > 0 {
+		return "", pp.diags
+	}
+	var stmts []string
+	for i := range pp.out {
+		st := parseStatement(&pp.out[i])
+		for _, l := range st.labels {
+			stmts = append(stmts, l.text+":")
+		}
+		if st.mnemonic != nil {
+			stmts = append(stmts, st.String())
+		}
+	}
+	return strings.Join(stmts, "\n"), nil
+}
+
+func TestMacro(t *testing.T) {
+	var table = []struct {
+		in       string
+		expected string
+	}{
+		{
+			".macro inc d, n=1\nADDi.64 \\d \\n\n.endm\ninc 1\ninc 2, 5\ninc n=3, d=4",
+			"ADDi.64 1, 1\nADDi.64 2, 5\nADDi.64 4, 3",
+		},
+		{
+			".macro loop\nl\\@: BNE 1 2 l\\@\n.endm\nloop\nloop",
+			"l1:\nBNE 1, 2, l1\nl2:\nBNE 1, 2, l2",
+		},
+		{
+			".macro pair a\nRMOV \\a\\()0\n.endm\npair 1",
+			"RMOV 10",
+		},
+		{
+			".rept 3\nNOP\n.endr",
+			"NOP\nNOP\nNOP",
+		},
+		{
+			".irp d, 1, 2\nRMOV \\d\n.endr",
+			"RMOV 1\nRMOV 2",
+		},
+		{
+			".macro outer x\n.rept 2\nRMOV \\x\n.endr\n.endm\nouter 7",
+			"RMOV 7\nRMOV 7",
+		},
+		{
+			".macro m\n.endm\nstart: m\nJ start",
+			"start:\nJ start",
+		},
+	}
+	for _, e := range table {
+		actual, err := preprocessString(e.in)
+		if err != nil {
+			t.Error(err)
+		} else if actual != e.expected {
+			t.Errorf("%q: %q (expected %q)", e.in, actual, e.expected)
+		}
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	var table = []struct {
+		in       string
+		expected string
+	}{
+		{".macro m\nNOP", "test.s:1:1: .macro without .endm"},
+		{".endm", "test.s:1:1: unexpected .endm"},
+		{".macro m a\n.endm\nm 1, 2", "test.s:3:6: too many arguments for macro 'm'"},
+		{".macro m a\n.endm\nm", "test.s:3:1: missing argument 'a' for macro 'm' defined at test.s:1:1"},
+		{".macro m\n.endm\n.macro m\n.endm", "test.s:3:1: macro 'm' is already defined at test.s:1:1"},
+		{".macro m\nm\n.endm\nm", "too deep expansion"},
+		{".rept x\n.endr", "test.s:1:1: invalid expression 'x'"},
+	}
+	for _, e := range table {
+		_, err := preprocessString(e.in)
+		if err == nil || !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%q: %v (expected %q)", e.in, err, e.expected)
+		}
+	}
+}
+
+func TestMacroDiagnostics(t *testing.T) {
+	a := assembler{}
+	src := ".macro bad x\nADDi.64 1 \\x\n.endm\nNOP\nbad 0x1000\n"
+	if err := a.load("test.s", strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	err := a.run()
+	if err == nil {
+		t.Fatal("should be an error")
+	}
+	// the line in the macro definition and the invocation line
+	if msg := err.Error(); !strings.HasPrefix(msg, "test.s:2:1: ") || !strings.HasSuffix(msg, "in expansion of macro 'bad' at test.s:5:1") {
+		t.Error(msg)
+	}
+}
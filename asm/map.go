@@ -0,0 +1,128 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// segmentNames : the segments buildELF makes, in order
+var segmentNames = []string{"text", "stack", "global data"}
+
+// flagString returns the segment flags as "RWX"
+func flagString(flags uint32) string {
+	s := []byte("---")
+	if flags&ProgFlagRead != 0 {
+		s[0] = 'R'
+	}
+	if flags&ProgFlagWrite != 0 {
+		s[1] = 'W'
+	}
+	if flags&ProgFlagExecute != 0 {
+		s[2] = 'X'
+	}
+	return string(s)
+}
+
+// sectionOf returns the section the address is in (or at the end of), or nil
+func (a *assembler) sectionOf(addr uint64) *section {
+	var end *section // a label at the end of a section (or in an empty one)
+	for _, s := range a.sections {
+		if s.addr <= addr && addr < s.addr+s.size {
+			return s
+		}
+		if addr == s.addr+s.size && (end == nil || s.size == 0) {
+			end = s
+		}
+	}
+	return end
+}
+
+// writeMap writes where the legalized elf puts the segments, the sections and the symbols,
+// the entry point, the initial SP and the free space in the global data region
+func (a *assembler) writeMap(w io.Writer, elf *ElfFile) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "Segments\n")
+	fmt.Fprintf(bw, "  %-12s %-5s %-10s %-10s %-10s %-10s\n", "segment", "flags", "offset", "vaddr", "filesz", "memsz")
+	for i, p := range elf.Programs {
+		name := fmt.Sprintf("#%d", i)
+		if i < len(segmentNames) {
+			name = segmentNames[i]
+		}
+		fmt.Fprintf(bw, "  %-12s %-5s 0x%08x 0x%08x 0x%08x 0x%08x\n", name, flagString(p.ProgFlags), p.ProgOffset, p.ProgVAddr, p.ProgFileSize, p.ProgMemSize)
+	}
+
+	// the sections of the assembler are in the text segment (after the headers) or in the global data one
+	fmt.Fprintf(bw, "\nSections\n")
+	fmt.Fprintf(bw, "  %-12s %-5s %-10s %-10s %-10s %-10s\n", "section", "flags", "offset", "vaddr", "filesz", "memsz")
+	for _, s := range a.sections {
+		flags := "R" + map[bool]string{true: "W", false: "-"}[s.write] + map[bool]string{true: "X", false: "-"}[s.exec]
+		offset, filesz := "-", uint64(0)
+		if !s.nobits {
+			seg := elf.Programs[2]
+			if s.exec {
+				seg = elf.Programs[0]
+			}
+			offset = fmt.Sprintf("0x%08x", uint64(seg.ProgOffset)+s.addr-uint64(seg.ProgVAddr))
+			filesz = s.size
+		}
+		fmt.Fprintf(bw, "  %-12s %-5s %-10s 0x%08x 0x%08x 0x%08x\n", s.name, flags, offset, s.addr, filesz, s.size)
+	}
+	fmt.Fprintf(bw, "\nELF sections\n")
+	fmt.Fprintf(bw, "  %-12s %-5s %-10s %-10s %-10s %-10s\n", "type", "", "offset", "vaddr", "filesz", "memsz")
+	for _, s := range elf.Sections {
+		typ := map[SecType]string{SecTypeNull: "NULL", SecTypeStrTab: "STRTAB"}[s.SecType]
+		fmt.Fprintf(bw, "  %-12s %-5s 0x%08x 0x%08x 0x%08x 0x%08x\n", typ, "", s.SecOffset, s.SecAddr, s.SecSize, s.SecSize)
+	}
+
+	// a symbol extends to the next one in its section (or to the end of the section);
+	// the local labels are not exported
+	type symbol struct {
+		name string
+		addr uint64
+		sec  *section
+	}
+	var syms []symbol
+	for name, addr := range a.symbols {
+		if isLocalLabel(name) {
+			continue
+		}
+		syms = append(syms, symbol{name, addr, a.sectionOf(addr)})
+	}
+	sort.Slice(syms, func(i, j int) bool {
+		if syms[i].addr != syms[j].addr {
+			return syms[i].addr < syms[j].addr
+		}
+		return syms[i].name < syms[j].name
+	})
+	fmt.Fprintf(bw, "\nSymbols\n")
+	fmt.Fprintf(bw, "  %-10s %-10s %-12s %s\n", "address", "size", "section", "name")
+	for i, s := range syms {
+		size, secName := uint64(0), "-"
+		if s.sec != nil {
+			end := s.sec.addr + s.sec.size
+			for _, t := range syms[i+1:] {
+				if t.sec == s.sec && t.addr > s.addr {
+					end = t.addr
+					break
+				}
+			}
+			size, secName = end-s.addr, s.sec.name
+		}
+		fmt.Fprintf(bw, "  0x%08x 0x%08x %-12s %s\n", s.addr, size, secName, sourceLabel(s.name))
+	}
+
+	l := a.opts.Layout
+	dataEnd := l.DataAddr
+	for _, s := range a.sections {
+		if !s.exec {
+			dataEnd = max(dataEnd, s.addr+s.size)
+		}
+	}
+	fmt.Fprintf(bw, "\nEntry point  0x%08x\n", uint64(elf.Header.ElfEntry))
+	fmt.Fprintf(bw, "Initial SP   0x%08x (the stack is 0x%08x-0x%08x)\n", l.InitialSP, l.InitialSP-l.StackSize, l.InitialSP)
+	fmt.Fprintf(bw, "Global data  0x%08x-0x%08x used, 0x%x bytes free of 0x%x\n", l.DataAddr, dataEnd, globalDataSize-dataEnd, globalDataSize)
+	return bw.Flush()
+}
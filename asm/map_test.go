@@ -0,0 +1,46 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	a := assembleString(t, `.data
+d: .dword 1, 2
+e: .byte 1
+.bss
+b: .space 100
+.text
+f: NOP
+! main: LUi 1
+1: ECALL
+`)
+	elf := a.buildELF()
+	elf.Legalize()
+	var sb strings.Builder
+	if err := a.writeMap(&sb, elf); err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+	for _, want := range []string{
+		"  text         R-X   0x00000000 0x20000000 0x000000f4 0x000000f4\n",
+		"  stack        RW-   0x000000f4 0x0aaffffc 0x00000000 0x00500000\n",
+		"  .text        R-X   0x000000e8 0x200000e8 0x0000000c 0x0000000c\n",
+		"  .bss         RW-   -          0x00010011 0x00000000 0x00000064\n",
+		"  0x00010000 0x00000010 .data        d\n",
+		"  0x00010010 0x00000001 .data        e\n",
+		"  0x00010011 0x00000064 .bss         b\n",
+		"  0x200000ec 0x00000008 .text        main\n",
+		"Entry point  0x200000ec\n",
+		"Initial SP   0x0afffffc",
+		"Global data  0x00010000-0x00010075 used, 0x1feff8b bytes free of 0x2000000\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, ".text        1\n") {
+		t.Errorf("the local label 1 in\n%s", out)
+	}
+}
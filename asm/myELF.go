@@ -1,9 +1,10 @@
-package main
+package asm
 
 import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"io"
 	"os"
 )
 
@@ -173,9 +174,10 @@ const ElfSecHeaderSize = (32*4 + 64*6) / 8 // bytes
 
 // ElfFile : ElfFile structure
 type ElfFile struct {
-	Header   ElfHeader
-	Programs []*ElfProgHeader
-	Sections []*ElfSecHeader
+	Header      ElfHeader
+	Programs    []*ElfProgHeader
+	Sections    []*ElfSecHeader
+	EntryOffset uint64 // the offset of the entry point from the first instruction
 }
 
 // ElfHeaderSize = sizeof(Header)
@@ -218,6 +220,18 @@ func NewELFFile() *ElfFile {
 }
 
 func (elf *ElfFile) WriteELFFile(fileName string) error {
+	fp, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := elf.WriteELF(fp); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+func (elf *ElfFile) WriteELF(fp io.Writer) error {
 	var bo binary.ByteOrder
 	if elf.Header.ElfIdent[ElfIdentDATA] == ElfIdentData2LSB {
 		bo = binary.LittleEndian
@@ -225,13 +239,8 @@ func (elf *ElfFile) WriteELFFile(fileName string) error {
 		bo = binary.BigEndian
 	}
 	elf.Legalize()
-	fp, err := os.Create(fileName)
-	if err != nil {
-		return err
-	}
-	defer fp.Close()
 
-	err = elf.Header.WriteELFHeader(fp, bo)
+	err := elf.Header.WriteELFHeader(fp, bo)
 	if err != nil {
 		return err
 	}
@@ -266,7 +275,7 @@ func (elf *ElfFile) WriteELFFile(fileName string) error {
 	return err
 }
 
-func (eh *ElfHeader) WriteELFHeader(fp *os.File, bo binary.ByteOrder) error {
+func (eh *ElfHeader) WriteELFHeader(fp io.Writer, bo binary.ByteOrder) error {
 	var ehb bytes.Buffer
 	binary.Write(&ehb, bo, eh)
 	_, err := fp.Write(ehb.Bytes())
@@ -295,12 +304,11 @@ func (elf *ElfFile) Legalize() error {
 	elf.Programs[0].ProgMemSize = elf.Programs[0].ProgFileSize
 	elf.Programs[0].ProgOffset = 0
 	elf.Programs[0].ProgAlign = 0
-	elf.Header.ElfEntry += ElfAddr(offset + uint64(entryOffset))
+	elf.Header.ElfEntry = elf.Programs[0].ProgVAddr + ElfAddr(offset+elf.EntryOffset)
 	offset += uint64(len(elf.Programs[0].Prog))
 
 	// .stack
 	elf.Programs[1].ProgFileSize = 0
-	elf.Programs[1].ProgMemSize = stackSize
 	elf.Programs[1].ProgOffset = ElfAddr(offset)  // maybe useless info
 	elf.Programs[1].ProgAlign = offset % PageSize // maybe useless info
 
@@ -334,7 +342,7 @@ func (elf *ElfFile) AddSegment(ph *ElfProgHeader) error {
 	return nil
 }
 
-func (ph *ElfProgHeader) WriteELFProgHeader(fp *os.File, bo binary.ByteOrder) error {
+func (ph *ElfProgHeader) WriteELFProgHeader(fp io.Writer, bo binary.ByteOrder) error {
 	phb := make([]byte, ElfProgHeaderSize)
 	offset := 0
 	bo.PutUint32(phb[offset:offset+4], uint32(ph.ProgType)) // TODO: use binary.Write
@@ -358,7 +366,7 @@ func (ph *ElfProgHeader) WriteELFProgHeader(fp *os.File, bo binary.ByteOrder) er
 	return err
 }
 
-func (sh *ElfSecHeader) WriteELFSecHeader(fp *os.File, bo binary.ByteOrder) error {
+func (sh *ElfSecHeader) WriteELFSecHeader(fp io.Writer, bo binary.ByteOrder) error {
 	shb := make([]byte, ElfSecHeaderSize)
 	offset := 0
 	bo.PutUint32(shb[offset:offset+4], sh.SecName)
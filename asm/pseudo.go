@@ -0,0 +1,404 @@
+package asm
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// pseudoInsts : pseudo-instruction -> expansion into machine instructions (in the canonical form).
+// The last instruction of an expansion produces the result. A numeric distance counts a pseudo-instruction
+// as one instruction, both to it (the result) and over it (see sourceDistances).
+var pseudoInsts = map[string]func(a *assembler, st *statement) ([]string, error){
+	"LI.32":     (*assembler).pseudoLI,
+	"LI.64":     (*assembler).pseudoLI,
+	"LA":        (*assembler).pseudoLA,
+	"LD.8":      (*assembler).pseudoLoadStore,
+	"LD.16":     (*assembler).pseudoLoadStore,
+	"LD.32":     (*assembler).pseudoLoadStore,
+	"LD.64":     (*assembler).pseudoLoadStore,
+	"LD.8u":     (*assembler).pseudoLoadStore,
+	"LD.16u":    (*assembler).pseudoLoadStore,
+	"LD.32u":    (*assembler).pseudoLoadStore,
+	"LD.f32":    (*assembler).pseudoLoadStore,
+	"ST.8":      (*assembler).pseudoLoadStore,
+	"ST.16":     (*assembler).pseudoLoadStore,
+	"ST.32":     (*assembler).pseudoLoadStore,
+	"ST.64":     (*assembler).pseudoLoadStore,
+	"CALL":      (*assembler).pseudoCall,
+	"TAIL":      (*assembler).pseudoCall,
+	"RET":       (*assembler).pseudoRet,
+	"BGT":       (*assembler).pseudoBranch,
+	"BLE":       (*assembler).pseudoBranch,
+	"BGTU":      (*assembler).pseudoBranch,
+	"BLEU":      (*assembler).pseudoBranch,
+	"BEQZ":      (*assembler).pseudoBranch,
+	"BNEZ":      (*assembler).pseudoBranch,
+	"BLTZ":      (*assembler).pseudoBranch,
+	"BGEZ":      (*assembler).pseudoBranch,
+	"CSRR":      (*assembler).pseudoCSR,
+	"CSRW":      (*assembler).pseudoCSR,
+	"RDCYCLE":   (*assembler).pseudoCSR,
+	"RDINSTRET": (*assembler).pseudoCSR,
+	"FRFLAGS":   (*assembler).pseudoCSR,
+	"FSRM":      (*assembler).pseudoCSR,
+}
+
+// pseudoBranches : pseudo branch -> the branch it is lowered to
+var pseudoBranches = map[string]string{
+	"BGT":  "BLT", // BGT a b L = BLT b a L
+	"BLE":  "BGE",
+	"BGTU": "BLTU",
+	"BLEU": "BGEU",
+	"BEQZ": "BEQ", // BEQZ a L = LUi 0; BEQ a+1 1 L
+	"BNEZ": "BNE",
+	"BLTZ": "BLT",
+	"BGEZ": "BGE",
+}
+
+// sourceDistances rewrites the numeric distances that count a pseudo-instruction as one instruction
+// (the ones to it or over it) into the distances of the instructions in the current layout:
+// a reference to the producer, or the number when they end in the NOPs of a directive.
+// The distances that don't reach a pseudo-instruction are already the ones of the layout.
+func (a *assembler) sourceDistances() {
+	for i, st := range a.stmts {
+		p := a.placed[i]
+		if p.end == p.start || st.isDirective() {
+			continue
+		}
+		for _, k := range distanceOperands(st) {
+			if k >= len(st.operands) {
+				continue
+			}
+			op := st.operands[k]
+			if len(op) != 1 || op[0].kind != tokNumber || !isNumericLabel(op[0].text) {
+				continue
+			}
+			d, err := strconv.ParseUint(op[0].text, 10, 32)
+			if err != nil || d == 0 {
+				continue
+			}
+			left, pseudo := int(d), false
+			for j := i - 1; j >= 0; j-- {
+				q, prev := a.placed[j], a.stmts[j]
+				if q.sec != p.sec || q.end == q.start {
+					continue
+				}
+				n := int((q.end - q.start) / instSize)
+				if _, ok := pseudoInsts[prev.mnemonic.text]; ok {
+					n, pseudo = 1, true
+				}
+				if left > n {
+					left -= n
+					continue
+				}
+				if !pseudo {
+					break
+				}
+				if left == 1 && !prev.isDirective() {
+					st.operands[k] = a.hiddenRef(prev, op[0].pos)
+				} else {
+					pc := q.end - uint64(left)*instSize
+					st.operands[k] = []token{{kind: tokNumber, text: strconv.FormatUint((p.start-pc)/instSize, 10), pos: op[0].pos, space: op[0].space}}
+				}
+				break
+			}
+		}
+	}
+}
+
+// memAccessSizes : load/store -> bytes accessed
+var memAccessSizes = map[string]int64{
+	"LD.8": 1, "LD.8u": 1, "ST.8": 1,
+	"LD.16": 2, "LD.16u": 2, "ST.16": 2,
+	"LD.32": 4, "LD.32u": 4, "LD.f32": 4, "ST.32": 4,
+	"LD.64": 8, "ST.64": 8,
+}
+
+// pseudoLI expands
+//
+//	LI.32 imm    (imm: 32 bit; the upper 32 bits of the result are unspecified)
+//	LI.64 imm
+//
+// into the shortest sequence of LUi, ADDi.64, SLLi.64 and OR.64 that materializes imm
+func (a *assembler) pseudoLI(st *statement) ([]string, error) {
+	ops := st.operandTexts()
+	if len(ops) != 1 {
+		return nil, fmt.Errorf("usage: %s imm", st.mnemonic.text)
+	}
+	v, err := a.constOperand(ops[0])
+	if err != nil {
+		return nil, err
+	}
+	if st.mnemonic.text == "LI.64" {
+		return liSequence(v), nil
+	}
+
+	if _, err := checkImm(ops[0], v, 32, immAny); err != nil {
+		return nil, err
+	}
+	// LUi + ADDi.64 is enough for the lower 32 bits, even if the upper 20 bits overflow by the rounding
+	lo := lo12(v)
+	seq := []string{fmt.Sprintf("LUi 0x%x", hi20(int64(int32(v))))}
+	if lo != 0 {
+		seq = append(seq, fmt.Sprintf("ADDi.64 1, %d", lo))
+	}
+	return seq, nil
+}
+
+// liSequence returns the shortest sequence found to materialize the 64-bit value v.
+// Each step refers to the previous one by distance and the last one produces v.
+func liSequence(v int64) []string {
+	lo := lo12(v)
+	hi := (v - lo) >> 12
+	if -1<<19 <= hi && hi < 1<<19 {
+		// LUi sign-extends imm20<<12 to 64 bit
+		seq := []string{fmt.Sprintf("LUi 0x%x", hi&0xfffff)}
+		if lo != 0 {
+			seq = append(seq, fmt.Sprintf("ADDi.64 1, %d", lo))
+		}
+		return seq
+	}
+
+	var best []string
+	try := func(seq []string) {
+		if best == nil || len(seq) < len(best) {
+			best = seq
+		}
+	}
+
+	// the value without the lower 12 bits, then ADDi.64
+	if lo != 0 {
+		try(append(liSequence(v-lo), fmt.Sprintf("ADDi.64 1, %d", lo)))
+	}
+
+	// the value shifted right, then SLLi.64 (keeping 12 zero bits for LUi, or as a whole)
+	if tz := bits.TrailingZeros64(uint64(v)); tz > 0 {
+		if tz > 12 {
+			try(append(liSequence(v>>(tz-12)), fmt.Sprintf("SLLi.64 1, %d", tz-12)))
+		}
+		try(append(liSequence(v>>tz), fmt.Sprintf("SLLi.64 1, %d", tz)))
+	}
+
+	// the upper and the lower 32 bits separately, then OR.64
+	if h, l := v>>32, v&0xffffffff; h != 0 && l != 0 {
+		seqL := liSequence(l)
+		var seq []string
+		if h == l {
+			seq = append(seqL, "SLLi.64 1, 32", "OR.64 1, 2")
+		} else {
+			seqH := liSequence(h)
+			seq = append(append(seqL, seqH...), "SLLi.64 1, 32", fmt.Sprintf("OR.64 1, %d", len(seqH)+2))
+		}
+		try(seq)
+	}
+	return best
+}
+
+// pseudoLA expands
+//
+//	LA label
+//
+// into AUiPC %pcrel_hi(label); ADDi.64 1, %pcrel_lo(label)
+func (a *assembler) pseudoLA(st *statement) ([]string, error) {
+	ops := st.operandTexts()
+	if len(ops) != 1 {
+		return nil, fmt.Errorf("usage: LA label")
+	}
+	return []string{
+		fmt.Sprintf("AUiPC %%pcrel_hi(%s)", ops[0]),
+		fmt.Sprintf("ADDi.64 1, %%pcrel_lo(%s)", ops[0]),
+	}, nil
+}
+
+// pseudoLoadStore expands the loads and the stores with a label instead of the address and the offset:
+//
+//	LD.64 label       -> AUiPC %pcrel_hi(label); LD.64 1, %pcrel_lo(label)
+//	ST.64 src label   -> AUiPC %pcrel_hi(label); ST.64 src+1, 1, %pcrel_lo(label)
+//
+// (src is counted again as the AUiPC comes in between). The other forms are left as they are.
+func (a *assembler) pseudoLoadStore(st *statement) ([]string, error) {
+	d := st.mnemonic.text
+	ops := st.operandTexts()
+	store := strings.HasPrefix(d, "ST.")
+	if !store && len(ops) != 1 || store && len(ops) != 2 {
+		return []string{st.String()}, nil
+	}
+	label := ops[len(ops)-1]
+
+	if a.final {
+		v, err := evalExpr(label, a.env())
+		if err != nil {
+			return nil, err
+		}
+		if size := memAccessSizes[d]; v.val%size != 0 {
+			return nil, fmt.Errorf("'%s' (0x%x) is not aligned to %d bytes for %s", label, v.val, size, d)
+		}
+	}
+	hi := fmt.Sprintf("AUiPC %%pcrel_hi(%s)", label)
+	if !store {
+		return []string{hi, fmt.Sprintf("%s 1, %%pcrel_lo(%s)", d, label)}, nil
+	}
+
+	src, err := shiftDistance(ops[0], 1)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s (AUiPC is inserted before it)", err, st)
+	}
+	return []string{hi, fmt.Sprintf("%s %s, 1, %%pcrel_lo(%s)", d, src, label)}, nil
+}
+
+// shiftDistance adds n to the distance s for the n instructions inserted in between
+func shiftDistance(s string, n uint64) (string, error) {
+	d, err := strconv.ParseUint(s, 10, 7)
+	if err != nil {
+		return "", fmt.Errorf("invalid distance '%s'", s)
+	}
+	if d == 0 {
+		return s, nil
+	}
+	if d+n >= 1<<7 {
+		return "", fmt.Errorf("distance %d becomes %d, which is out of range", d, d+n)
+	}
+	return strconv.FormatUint(d+n, 10), nil
+}
+
+// pseudoCall expands
+//
+//	CALL func -> JAL func                  (near)
+//	             AUiPC %pcrel_hi(func); JALR 1, %pcrel_lo(func)  (far)
+//	TAIL func -> J func                    (near)
+//	             AUiPC %pcrel_hi(func); JR 1, %pcrel_lo(func)    (far)
+//
+// The far form is used once the target is out of the range of JAL/J (the choice never goes back,
+// so that the passes settle). The link value is produced by the last instruction in both forms.
+func (a *assembler) pseudoCall(st *statement) ([]string, error) {
+	ops := st.operandTexts()
+	if len(ops) != 1 {
+		return nil, fmt.Errorf("usage: %s func", st.mnemonic.text)
+	}
+	near, far := "JAL", "JALR"
+	if st.mnemonic.text == "TAIL" {
+		near, far = "J", "JR"
+	}
+
+	if !a.far[st] && a.passes >= 2 { // the symbols of the 1st pass are before the layout
+		v, err := evalExpr(ops[0], a.env())
+		if err != nil && a.final {
+			return nil, err
+		}
+		if err == nil {
+			off := (v.val - int64(a.cur.pc())) / instSize
+			a.far[st] = v.addr && (off < -1<<19 || off >= 1<<19)
+		}
+	}
+	if !a.far[st] {
+		return []string{near + " " + ops[0]}, nil
+	}
+	return []string{
+		fmt.Sprintf("AUiPC %%pcrel_hi(%s)", ops[0]),
+		fmt.Sprintf("%s 1, %%pcrel_lo(%s)", far, ops[0]),
+	}, nil
+}
+
+// pseudoRet expands
+//
+//	RET func -> JR n, 0
+//
+// where n is the distance to the link value of the call, which is produced right before the entry func:
+// the code from func to RET has to be straight (no branch into it). RET n takes the distance as it is.
+func (a *assembler) pseudoRet(st *statement) ([]string, error) {
+	ops := st.operandTexts()
+	if len(ops) != 1 {
+		return nil, fmt.Errorf("usage: RET func")
+	}
+	if !a.final {
+		return []string{"JR 1, 0"}, nil // the size
+	}
+	v, err := evalExpr(ops[0], a.env())
+	if err != nil {
+		return nil, err
+	}
+	if !v.addr {
+		return []string{fmt.Sprintf("JR %d, 0", v.val)}, nil
+	}
+	pc := int64(a.cur.pc())
+	if v.val > pc || (pc-v.val)%instSize != 0 {
+		return nil, fmt.Errorf("RET %s: '%s' (0x%x) is not an instruction before 0x%x", ops[0], ops[0], v.val, pc)
+	}
+	n := (pc-v.val)/instSize + 1
+	if n >= 1<<7 {
+		return nil, fmt.Errorf("RET %s: the link value is %d instructions back (max %d)", ops[0], n, 1<<7-1)
+	}
+	return []string{fmt.Sprintf("JR %d, 0", n)}, nil
+}
+
+// pseudoBranch expands
+//
+//	BGT a b L, BLE a b L, BGTU a b L, BLEU a b L -> BLT/BGE/BLTU/BGEU b a L
+//	BEQZ a L, BNEZ a L, BLTZ a L, BGEZ a L       -> LUi 0; BEQ/BNE/BLT/BGE a+1 1 L
+//
+// The zero-compare forms produce the zero to compare with. A numeric target (not a label)
+// stays relative to the pseudo-instruction.
+func (a *assembler) pseudoBranch(st *statement) ([]string, error) {
+	d := st.mnemonic.text
+	ops := st.operandTexts()
+	if !strings.HasSuffix(d, "Z") {
+		if len(ops) != 3 {
+			return nil, fmt.Errorf("usage: %s a b target", d)
+		}
+		return []string{fmt.Sprintf("%s %s, %s, %s", pseudoBranches[d], ops[1], ops[0], ops[2])}, nil
+	}
+
+	if len(ops) != 2 {
+		return nil, fmt.Errorf("usage: %s a target", d)
+	}
+	src, err := shiftDistance(ops[0], 1)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s (LUi 0 is inserted before it)", err, st)
+	}
+	target := ops[1]
+	if v, err := evalExpr(target, a.env()); err == nil && !v.addr {
+		target = strconv.FormatInt(v.val-1, 10)
+	}
+	return []string{"LUi 0", fmt.Sprintf("%s %s, 1, %s", pseudoBranches[d], src, target)}, nil
+}
+
+// pseudoCSRReads : the pseudo-instructions that read a CSR -> the CSR
+var pseudoCSRReads = map[string]string{"RDCYCLE": "cycle", "RDINSTRET": "instret", "FRFLAGS": "fflags"}
+
+// pseudoCSR expands the CSR pseudo-instructions into "CSRRx src csr":
+//
+//	CSRR csr      -> CSRRSi 0 csr  (read)
+//	CSRW src csr  -> CSRRW src csr (write)
+//	RDCYCLE       -> CSRRSi 0 cycle
+//	RDINSTRET     -> CSRRSi 0 instret
+//	FRFLAGS       -> CSRRSi 0 fflags
+//	FSRM src      -> CSRRW src frm
+//
+// The reads set no bit (zImm 0), so the CSR is left as it is.
+func (a *assembler) pseudoCSR(st *statement) ([]string, error) {
+	d := st.mnemonic.text
+	ops := st.operandTexts()
+	switch d {
+	case "CSRR":
+		if len(ops) != 1 {
+			return nil, fmt.Errorf("usage: CSRR csr")
+		}
+		return []string{"CSRRSi 0, " + ops[0]}, nil
+	case "CSRW":
+		if len(ops) != 2 {
+			return nil, fmt.Errorf("usage: CSRW src csr")
+		}
+		return []string{fmt.Sprintf("CSRRW %s, %s", ops[0], ops[1])}, nil
+	case "FSRM":
+		if len(ops) != 1 {
+			return nil, fmt.Errorf("usage: FSRM src")
+		}
+		return []string{fmt.Sprintf("CSRRW %s, frm", ops[0])}, nil
+	}
+	if len(ops) != 0 {
+		return nil, fmt.Errorf("%s takes no operands", d)
+	}
+	return []string{"CSRRSi 0, " + pseudoCSRReads[d]}, nil
+}
@@ -0,0 +1,256 @@
+package asm
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// runSequence computes the values produced by a sequence of LUi/ADDi.64/SLLi.64/OR.64 and returns the last one
+func runSequence(t *testing.T, seq []string) int64 {
+	var vals []int64
+	for _, s := range seq {
+		i, err := strToInst(s, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := func(d uint32) int64 {
+			if d == 0 || int(d) > len(vals) {
+				t.Fatalf("%s: distance %d is out of the sequence %v", s, d, seq)
+			}
+			return vals[len(vals)-int(d)]
+		}
+		var v int64
+		switch i := i.(type) {
+		case *instTypeNoReg:
+			v = int64(int32(i.imm20 << 12))
+		case *instTypeOneReg:
+			if i.operation == opSLLi64 {
+				v = src(i.srcReg) << (i.imm12 >> 5)
+			} else {
+				v = src(i.srcReg) + int64(extractBits(uint64(i.imm12), 12, true))
+			}
+		case *instTypeTwoReg:
+			v = src(i.srcRegs[0]) | src(i.srcRegs[1])
+		}
+		vals = append(vals, v)
+	}
+	return vals[len(vals)-1]
+}
+
+func TestLISequence(t *testing.T) {
+	var table = []struct {
+		v   int64
+		len int
+	}{
+		{0, 1},
+		{1, 2},
+		{-1, 2},
+		{0x7ff, 2},
+		{0x1000, 1},
+		{0x12345678, 2},
+		{-0x80000000, 1},
+		{0x7fffffff, 3},
+		{0xffffffff, 3},
+		{0x100000000, 2},
+		{0x123456789abcdef0, 0},
+		{0x0000123400001234, 4},
+		{-0x8000000000000000, 2},
+		{0x7fffffffffffffff, 0},
+	}
+	for _, e := range table {
+		seq := liSequence(e.v)
+		if actual := runSequence(t, seq); actual != e.v {
+			t.Errorf("0x%x: %v produces 0x%x", e.v, seq, actual)
+		}
+		if e.len != 0 && len(seq) != e.len {
+			t.Errorf("0x%x: %v (expected %d instructions)", e.v, seq, e.len)
+		}
+		if len(seq) > 8 {
+			t.Errorf("0x%x: %v is too long", e.v, seq)
+		}
+	}
+
+	v := uint64(1)
+	for n := 0; n < 1000; n++ {
+		v = v*6364136223846793005 + 1442695040888963407
+		if seq := liSequence(int64(v)); runSequence(t, seq) != int64(v) {
+			t.Errorf("0x%x: %v", v, seq)
+		}
+	}
+}
+
+func TestLI(t *testing.T) {
+	// 2 instructions each
+	a := assembleString(t, "LI.32 0x7ffff800\nLI.32 -5\nLI.64 end\nend: ADD.64 1 2\n")
+	if a.symbols["end"] != textStartAddr+24 {
+		t.Errorf("end: 0x%x", a.symbols["end"])
+	}
+	// the distances count LI as one instruction (the last one)
+	if d := instDistances(a.insts[6].inst); d[0] != 1 || d[1] != 3 {
+		t.Errorf("ADD.64 1 2: %v", d)
+	}
+
+	for _, src := range []string{"LI.32 0x100000000", "LI.64", "LI.32 1, 2"} {
+		a := assembler{}
+		if err := a.load("test.s", strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("%q should be an error", src)
+		}
+	}
+}
+
+func TestLA(t *testing.T) {
+	a := assembleString(t, `
+.data
+.space 0x7ff
+b: .byte 1
+.align 3
+d: .dword 2
+.text
+LA d
+LD.64 d
+ST.8 3, b
+`)
+	text := a.sections[0]
+	word := func(n int) uint32 {
+		return binary.LittleEndian.Uint32(text.data[n*instSize:])
+	}
+	// AUiPC + the 12 bit immediate of the next one
+	check := func(n int, lo uint32, target string) {
+		pc := int64(text.addr) + int64(n*instSize)
+		v := pc + int64(int32(word(n)&^0xfff)) + int64(extractBits(uint64(lo), 12, true))
+		if v != int64(a.symbols[target]) {
+			t.Errorf("instruction %d: 0x%x (expected %s = 0x%x)", n, v, target, a.symbols[target])
+		}
+	}
+	check(0, word(1)>>13&0xfff, "d")
+	check(2, word(3)>>13&0xfff, "d")
+	check(4, word(5)>>6&0xfff, "b")
+	if src := word(5) >> 25; src != 4 {
+		t.Errorf("ST.8: the distance of the value is %d", src)
+	}
+
+	// the distances count LA and ST.64 as one instruction
+	a = assembleString(t, "LUi 7\nLA d\nST.64 2, d\nADD.64 3, 2\n.data\nd: .dword 0\n")
+	if d := instDistances(a.insts[4].inst); d[0] != 4 || d[1] != 1 {
+		t.Errorf("ST.64 2, d: %v", d)
+	}
+	if d := instDistances(a.insts[5].inst); d[0] != 5 || d[1] != 3 {
+		t.Errorf("ADD.64 3, 2: %v", d)
+	}
+
+	for _, src := range []string{".data\n.byte 0\nd: .word 0\n.text\nLD.32 d", "LA", "ST.8 127, d\nd:"} {
+		a := assembler{}
+		if err := a.load("test.s", strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("%q should be an error", src)
+		}
+	}
+	if _, err := evalExpr("%pcrel_hi(0)", &asmEnv{pc: 0x100000000}); err == nil {
+		t.Errorf("%%pcrel_hi should be out of range")
+	}
+}
+
+// checkText compares the beginning of the .text section with the instructions expected
+func checkText(t *testing.T, a *assembler, expected []string) {
+	text := a.sections[0]
+	if len(text.data) < len(expected)*instSize {
+		t.Errorf(".text: %d bytes (expected %d instructions)", len(text.data), len(expected))
+		return
+	}
+	for n, s := range expected {
+		env := &asmEnv{pc: text.addr + uint64(n*instSize), symbols: a.symbols}
+		i, err := strToInst(s, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bs := instToBytes(i); string(bs[:]) != string(text.data[n*instSize:(n+1)*instSize]) {
+			t.Errorf("instruction %d: %v (expected %s)", n, text.data[n*instSize:(n+1)*instSize], s)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	a := assembleString(t, `
+CALL near
+TAIL far
+near: ADD.64 1, 2
+NOP
+RET near
+.space 0x200000
+far: NOP
+`)
+	// (the distance 2 of ADD.64 goes over TAIL as one instruction)
+	checkText(t, a, []string{"JAL 3", "AUiPC %pcrel_hi(far)", "JR 1, %pcrel_lo(far)", "ADD.64 1, 3", "NOP", "JR 3, 0"})
+	if a.symbols["far"] != textStartAddr+6*instSize+0x200000 {
+		t.Errorf("far: 0x%x", a.symbols["far"])
+	}
+
+	for _, src := range []string{"RET later\nlater:", "CALL", "f: NOP\n.space 1024\nRET f"} {
+		a := assembler{}
+		if err := a.load("test.s", strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("%q should be an error", src)
+		}
+	}
+}
+
+func TestPseudoBranch(t *testing.T) {
+	a := assembleString(t, `
+l: BGT 1, 2, l
+BLEU 3, 4, l
+BEQZ 2, l
+BGEZ 126, 8
+`)
+	checkText(t, a, []string{"BLT 2, 1, l", "BGEU 4, 3, l", "LUi 0", "BEQ 3, 1, l", "LUi 0", "BGE 127, 1, 7"})
+
+	for _, src := range []string{"BGT 1, 2", "BNEZ 127, 0", "BLTZ 1"} {
+		a := assembler{}
+		if err := a.load("test.s", strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("%q should be an error", src)
+		}
+	}
+}
+
+func TestPseudoCSR(t *testing.T) {
+	a := assembleString(t, `
+CSRR instret
+CSRW 2 mtvec
+RDCYCLE
+RDINSTRET
+FRFLAGS
+FSRM 4
+`)
+	checkText(t, a, []string{"CSRRSi 0, 0xc02", "CSRRW 2, 0x305", "CSRRSi 0, 0xc00", "CSRRSi 0, 0xc02", "CSRRSi 0, 0x1", "CSRRW 4, 0x2"})
+
+	for _, src := range []string{"CSRR", "CSRW 1", "RDCYCLE 1", "FSRM"} {
+		a := assembler{}
+		if err := a.load("test.s", strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("%q should be an error", src)
+		}
+	}
+}
+
+func TestPseudoDistances(t *testing.T) {
+	// a distance counts BEQZ (LUi 0 + BEQ) as one instruction, also from the branch target
+	a := assembleString(t, `
+! ADDi.64 0, 5
+BEQZ 1, skip
+ADD.64 2, 2
+skip: ADD.64 3, 1
+`)
+	checkText(t, a, []string{"ADDi.64 0, 5", "LUi 0", "BEQ 2, 1, skip", "ADD.64 3, 3", "ADD.64 4, 1"})
+}
@@ -0,0 +1,301 @@
+package asm
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// placement is where the instructions of a statement are in the last pass
+type placement struct {
+	sec        *section
+	start, end uint64 // [start, end); start == end for a statement without instructions
+}
+
+// longRef is a reference to a value more than maxDistance instructions back
+type longRef struct {
+	stmt int    // the consumer
+	name string // the value referred to
+	def  valueDef
+}
+
+// distanceOperands returns the indices of the operands of st that are distances to source values
+func distanceOperands(st *statement) []int {
+	m := st.mnemonic.text
+	n := len(st.operands)
+	switch {
+	case m == "RET":
+		return []int{0}
+	case m == "BGT" || m == "BLE" || m == "BGTU" || m == "BLEU":
+		return []int{0, 1}
+	case m == "BEQZ" || m == "BNEZ" || m == "BLTZ" || m == "BGEZ":
+		return []int{0}
+	case m == "CSRW" || m == "FSRM":
+		return []int{0}
+	case strings.HasPrefix(m, "LD.") && n == 1:
+		return nil // LD.x label
+	case strings.HasPrefix(m, "ST.") && n == 2:
+		return []int{0} // ST.x src label
+	}
+	if _, ok := strToSBOperation[m]; ok {
+		return []int{0, 1}
+	}
+	if _, ok := strToTwoRegOperation[m]; ok {
+		return []int{0, 1}
+	}
+	if _, ok := strToMacOperation[m]; ok {
+		return []int{0, 1, 2}
+	}
+	if op, ok := strToFloatOperation[m]; ok {
+		if floatSources(op) == 1 {
+			return []int{0}
+		}
+		return []int{0, 1}
+	}
+	if op, ok := strToOneRegOperation[m]; ok {
+		switch op {
+		case opRPINC, opFENCE, opFENCEI, opECALL, opCSRRWi, opCSRRSi, opCSRRCi,
+			opSPLD8, opSPLD16, opSPLD32, opSPLD64, opSPLD8u, opSPLD16u, opSPLD32u, opSPLD32f:
+			return nil
+		}
+		return []int{0}
+	}
+	return nil // NoReg, LI, LA, CALL, TAIL
+}
+
+// floatSources returns the number of the source operands of the floating point operation
+func floatSources(op floatOperation) int {
+	switch op {
+	case opFCVTf64tof32, opFCVTf32toi32, opFCVT32us, opFCVTs32, opFCVTs32u, opFCVT64s, opFCVT64us, opFCVTs64, opFCVTs64u,
+		opFCVTds, opFCVT32d, opFCVT32ud, opFCVTd32, opFCVTd32u, opFCVT64d, opFCVT64ud, opFCVTd64, opFCVTd64u:
+		return 1
+	}
+	return 2
+}
+
+// branchOffsetOperand returns the index of the operand of st that is the branch target, or -1
+func branchOffsetOperand(st *statement) int {
+	m := st.mnemonic.text
+	switch {
+	case m == "J" || m == "JAL":
+		return 0
+	case m == "BEQZ" || m == "BNEZ" || m == "BLTZ" || m == "BGEZ":
+		return 1
+	case pseudoBranches[m] != "":
+		return 2
+	}
+	if _, ok := strToSBOperation[m]; ok && !strings.HasPrefix(m, "ST.") {
+		return 2
+	}
+	return -1
+}
+
+// hiddenRef returns the operand that refers to the value of st by a name the source can't write ("%#3")
+func (a *assembler) hiddenRef(st *statement, pos srcPos) []token {
+	if st.hidden == "" {
+		a.hiddenNames++
+		st.hidden = "#" + strconv.Itoa(a.hiddenNames)
+	}
+	return []token{{kind: tokPunct, text: "%", pos: pos, space: true}, {kind: tokIdent, text: st.hidden, pos: pos}}
+}
+
+// isHidden reports whether the named value is one given by hiddenRef
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, "#")
+}
+
+// symbolizeDistances replaces the numeric distances with references to the producers in the current layout,
+// so that they follow the instructions inserted in between. The distances that don't reach an instruction
+// (the beginning of the section, the middle of a pseudo-instruction) are left as they are.
+func (a *assembler) symbolizeDistances() {
+	producers := map[*section]map[uint64]int{} // the last instruction of a statement -> the statement
+	for i, p := range a.placed {
+		if p.end > p.start {
+			if producers[p.sec] == nil {
+				producers[p.sec] = map[uint64]int{}
+			}
+			producers[p.sec][p.end-instSize] = i
+		}
+	}
+	for i, st := range a.stmts {
+		p := a.placed[i]
+		if p.end == p.start || st.isDirective() {
+			continue
+		}
+		for _, k := range distanceOperands(st) {
+			if k >= len(st.operands) {
+				continue
+			}
+			op := st.operands[k]
+			if len(op) != 1 || op[0].kind != tokNumber || !isNumericLabel(op[0].text) {
+				continue
+			}
+			d, err := strconv.ParseUint(op[0].text, 10, 32)
+			if err != nil || d == 0 || d*instSize > p.start-p.sec.addr {
+				continue
+			}
+			if j, ok := producers[p.sec][p.start-d*instSize]; ok {
+				st.operands[k] = a.hiddenRef(a.stmts[j], op[0].pos)
+			}
+		}
+	}
+}
+
+// breaksPath reports whether the straight-line path is broken right before the statement i:
+// a branch target, the entry or an unconditional jump just before it
+func (a *assembler) breaksPath(i int) bool {
+	if a.pathBreak(a.stmts[i]) != "" {
+		return true
+	}
+	for j := i - 1; j >= 0; j-- {
+		if a.placed[j].end > a.placed[j].start {
+			st := a.stmts[j]
+			return st.mnemonic != nil && unconditionalJumps[st.mnemonic.text]
+		}
+	}
+	return false
+}
+
+// pinned returns the statements before which no instruction can be inserted: the ones in
+//
+//   - a reference to a value that crosses a break of the path (the other paths would not see the insertion)
+//   - a branch with a numeric offset (the offset would not follow the insertion)
+func (a *assembler) pinned() []bool {
+	pinned := make([]bool, len(a.stmts)+1)
+	index := map[string]int{}
+	for i, st := range a.stmts {
+		if st.hidden != "" {
+			index[st.hidden] = i
+		}
+	}
+	for i, st := range a.stmts {
+		p := a.placed[i]
+		if p.end == p.start || st.isDirective() {
+			continue
+		}
+		for _, op := range st.operands {
+			name, ok := valueRef(op)
+			if !ok || !isHidden(name) {
+				continue
+			}
+			j, ok := index[name]
+			if !ok || j >= i {
+				continue
+			}
+			last := -1
+			for k := j + 1; k <= i; k++ {
+				if a.breaksPath(k) {
+					last = k
+				}
+			}
+			for k := j + 1; k <= last; k++ {
+				pinned[k] = true
+			}
+		}
+
+		if k := branchOffsetOperand(st); k >= 0 && k < len(st.operands) {
+			v, err := evalExpr(joinTokens(st.operands[k]), nil)
+			if err != nil {
+				continue // a label
+			}
+			from := int64(p.end - instSize)
+			to := from + v.val*instSize
+			for n := range a.stmts {
+				q := a.placed[n]
+				if q.sec != p.sec || q.end == q.start {
+					continue
+				}
+				at := int64(q.start)
+				if to > from && from < at && at <= to || to <= from && to <= at && at <= from {
+					pinned[n] = true
+				}
+			}
+		}
+	}
+	return pinned
+}
+
+// insertRelays handles the long references of the last pass. A consumer that reaches a relay of the value
+// on its straight-line path refers to it; otherwise "RMOV %value" is inserted at the latest point that
+// the nearest relay (or the value itself) reaches, with no path break up to the consumer. One relay is
+// inserted for each value at a time. It returns the number of the references changed.
+func (a *assembler) insertRelays() int {
+	if len(a.longRefs) == 0 {
+		return 0
+	}
+	pinned := a.pinned()
+	type insertion struct {
+		at    int
+		relay *statement
+	}
+	var insertions []insertion
+	changes := 0
+	done := map[*statement]bool{} // the values relayed in this round
+	for _, ref := range a.longRefs {
+		origin := a.stmts[ref.def.stmt]
+		if o, ok := a.relayed[origin]; ok {
+			origin = o
+		}
+		if done[origin] {
+			continue
+		}
+		consumer := a.stmts[ref.stmt]
+		head := ref.def.stmt
+		for k := ref.stmt; k > ref.def.stmt; k-- {
+			if k < ref.stmt && a.relayed[a.stmts[k]] == origin {
+				head = k
+				break
+			}
+			if a.breaksPath(k) {
+				break
+			}
+		}
+		headPC := a.placed[head].end - instSize
+		retarget := func(st *statement) {
+			for i, op := range consumer.operands {
+				if name, ok := valueRef(op); ok && name == ref.name {
+					consumer.operands[i] = a.hiddenRef(st, op[0].pos)
+				}
+			}
+			changes++
+		}
+		if (a.placed[ref.stmt].start-headPC)/instSize <= maxDistance {
+			retarget(a.stmts[head])
+			continue
+		}
+
+		// (the relay moves the consumer too: it has to be at least 2 instructions after the head to get closer)
+		at := -1
+		for k := ref.stmt; k > head && a.placed[k].start >= headPC+2*instSize; k-- {
+			if a.breaksPath(k) {
+				break
+			}
+			q := a.placed[k]
+			if q.sec == ref.def.sec && q.end > q.start && !pinned[k] && (q.start-headPC)/instSize <= maxDistance {
+				at = k
+				break
+			}
+		}
+		if at < 0 {
+			continue // the final pass reports it
+		}
+		pos := a.stmts[at].pos()
+		relay := &statement{
+			line:     a.stmts[at].line,
+			inserted: true,
+			mnemonic: &token{kind: tokIdent, text: "RMOV", pos: pos},
+			operands: [][]token{a.hiddenRef(a.stmts[head], pos)},
+		}
+		a.relayed[relay] = origin
+		insertions = append(insertions, insertion{at, relay})
+		done[origin] = true
+		retarget(relay)
+	}
+
+	sort.SliceStable(insertions, func(i, j int) bool { return insertions[i].at > insertions[j].at })
+	for _, ins := range insertions {
+		a.stmts = append(a.stmts[:ins.at], append([]*statement{ins.relay}, a.stmts[ins.at:]...)...)
+	}
+	a.relays += len(insertions)
+	return changes
+}
@@ -0,0 +1,76 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+// assembleRelay assembles src with the relays enabled
+func assembleRelay(t *testing.T, src string) *assembler {
+	a := assembler{opts: Options{Relay: true}}
+	if err := a.load("test.s", strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(); err != nil {
+		t.Fatal(err)
+	}
+	return &a
+}
+
+// checkInst compares the n-th instruction of .text with s
+func checkInst(t *testing.T, a *assembler, n int, s string) {
+	text := a.sections[0]
+	i, err := strToInst(s, &asmEnv{pc: text.addr + uint64(n*instSize), symbols: a.symbols})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs := instToBytes(i); string(bs[:]) != string(text.data[n*instSize:(n+1)*instSize]) {
+		t.Errorf("instruction %d: %v (expected %s)", n, text.data[n*instSize:(n+1)*instSize], s)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	// the relay goes as far as the value reaches; the distances over it are rewritten
+	a := assembleRelay(t, `
+%v = LUi 1
+.rept 199
+NOP
+.endr
+ADD.64 %v, 100
+ADD.64 201, 1
+`)
+	if a.relays != 1 {
+		t.Errorf("%d relays", a.relays)
+	}
+	checkInst(t, a, 127, "RMOV 127")
+	checkInst(t, a, 201, "ADD.64 74, 101")
+	checkInst(t, a, 202, "ADD.64 75, 1")
+
+	// a chain of relays
+	a = assembleRelay(t, "%v = LUi 1\n.rept 300\nNOP\n.endr\nRMOV %v\n")
+	if a.relays != 2 {
+		t.Errorf("%d relays", a.relays)
+	}
+	checkInst(t, a, 127, "RMOV 127")
+	checkInst(t, a, 254, "RMOV 127")
+	checkInst(t, a, 303, "RMOV 49")
+
+	// labels and directives in between
+	a = assembleRelay(t, "%v = LUi 1\n.rept 150\nNOP\n.endr\n.align 2\nl:\n.rept 50\nNOP\n.endr\nRMOV %v")
+	checkInst(t, a, 127, "RMOV 127")
+
+	for _, src := range []string{
+		"%x = NOP\n.space 512\nRMOV %x",                         // nowhere to put the relay
+		"%x = NOP\n.rept 200\nNOP\n.endr\nl: RMOV %x\nJ l",      // the path is broken
+		"LUi 1\nJ l\n.rept 150\nNOP\n.endr\nl: NOP\nRMOV 153",   // a relay after l would be only on one path
+		"LUi 1\nBEQ 1, 1, 140\n.rept 200\nNOP\n.endr\nRMOV 202", // the offset of BEQ would change
+	} {
+		a := assembler{opts: Options{Relay: true}}
+		if err := a.load("test.s", strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("%q should be an error", src)
+		}
+	}
+}
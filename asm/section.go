@@ -0,0 +1,214 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// globalDataSize : size of the global data region (the 3rd segment)
+const globalDataSize = 33554432
+
+// section is a named piece of the program that statements are assembled into.
+// Executable sections are put into the .text segment and the others into the global data region.
+type section struct {
+	name   string
+	exec   bool // 'x': instructions
+	write  bool // 'w'
+	nobits bool // only occupies memory (.bss)
+	align  uint64
+	addr   uint64 // decided by layout
+	size   uint64 // location counter
+	data   []byte // contents (not for nobits)
+}
+
+func (s *section) pc() uint64 {
+	return s.addr + s.size
+}
+
+func (s *section) emit(b []byte) {
+	if !s.nobits {
+		s.data = append(s.data, b...)
+	}
+	s.size += uint64(len(b))
+}
+
+// flags returns the flags in the same form as the .section directive
+func (s *section) flags() string {
+	f := "a"
+	if s.write {
+		f += "w"
+	}
+	if s.exec {
+		f += "x"
+	}
+	return f
+}
+
+// newSection creates a section with the default attributes for its name
+func newSection(name string) *section {
+	s := section{name: name, align: 1}
+	switch {
+	case name == ".text" || strings.HasPrefix(name, ".text."):
+		s.exec = true
+	case name == ".rodata" || strings.HasPrefix(name, ".rodata."):
+	case name == ".bss" || strings.HasPrefix(name, ".bss."):
+		s.write = true
+		s.nobits = true
+	default:
+		s.write = true
+	}
+	if s.exec {
+		s.align = instSize
+	}
+	return &s
+}
+
+func (a *assembler) switchSection(name string) *section {
+	for _, s := range a.sections {
+		if s.name == name {
+			a.cur = s
+			return s
+		}
+	}
+	s := newSection(name)
+	a.sections = append(a.sections, s)
+	a.cur = s
+	return s
+}
+
+// dirSection handles .text, .data, .rodata, .bss and
+//
+//	.section name[, "flags"[, @progbits|@nobits]]
+//
+// flags are 'a' (ignored: every section is allocated), 'w' and 'x'.
+// The flags given at the first .section of a name are used.
+func (a *assembler) dirSection(st *statement) error {
+	dir := st.mnemonic.text
+	if dir != ".section" {
+		if len(st.operands) != 0 {
+			return fmt.Errorf("%s takes no operands", dir)
+		}
+		a.switchSection(dir)
+		return nil
+	}
+
+	ops := st.operandTexts()
+	if len(ops) == 0 || len(ops) > 3 {
+		return fmt.Errorf(`usage: .section name[, "flags"[, @progbits|@nobits]]`)
+	}
+	name := ops[0]
+	if uq, err := strconv.Unquote(name); err == nil {
+		name = uq
+	}
+	if name == "" {
+		return fmt.Errorf("empty section name")
+	}
+	isNew := true
+	for _, s := range a.sections {
+		isNew = isNew && s.name != name
+	}
+	s := a.switchSection(name)
+	if len(ops) == 1 {
+		return nil
+	}
+
+	// the flags are checked every pass (the errors are reported in the final one), and set on the first
+	flags, err := strconv.Unquote(ops[1])
+	if err != nil {
+		return fmt.Errorf("invalid section flags %s", ops[1])
+	}
+	exec, write, nobits := false, false, s.nobits
+	for _, f := range flags {
+		switch f {
+		case 'a':
+		case 'w':
+			write = true
+		case 'x':
+			exec = true
+		default:
+			return fmt.Errorf("unknown section flag '%c' in %s", f, ops[1])
+		}
+	}
+	if len(ops) == 3 {
+		switch ops[2] {
+		case "@progbits":
+			nobits = false
+		case "@nobits":
+			nobits = true
+		default:
+			return fmt.Errorf("unknown section type %s", ops[2])
+		}
+	}
+	if exec && nobits {
+		return fmt.Errorf("section %s can't be both executable and @nobits", name)
+	}
+	if !isNew {
+		return nil
+	}
+	s.exec, s.write, s.nobits = exec, write, nobits
+	if s.exec {
+		s.align = instSize
+	}
+	return nil
+}
+
+func alignUp(v, align uint64) uint64 {
+	return (v + align - 1) / align * align
+}
+
+// layout assigns addresses to the sections:
+// the executable ones from the start of the text, then the other ones from the data address of the layout (@nobits ones last).
+// It reports whether any address has changed.
+func (a *assembler) layout() (bool, error) {
+	changed := false
+	place := func(s *section, addr uint64) uint64 {
+		addr = alignUp(addr, s.align)
+		changed = changed || s.addr != addr
+		s.addr = addr
+		return addr + s.size
+	}
+
+	text := a.opts.Layout.textStart()
+	for _, s := range a.sections {
+		if s.exec {
+			text = place(s, text)
+		}
+	}
+	data := a.opts.Layout.DataAddr
+	for _, s := range a.sections {
+		if !s.exec && !s.nobits {
+			data = place(s, data)
+		}
+	}
+	for _, s := range a.sections {
+		if s.nobits {
+			data = place(s, data)
+		}
+	}
+	if data > globalDataSize {
+		return changed, fmt.Errorf("too much global data: 0x%x bytes (max 0x%x)", data, globalDataSize)
+	}
+	return changed, nil
+}
+
+// segmentImage concatenates the contents of the executable (or the other) sections
+// into the image of a segment that starts at start. The gaps between the executable ones are NOPs.
+func (a *assembler) segmentImage(exec bool, start uint64) []byte {
+	var img []byte
+	nop := instToBytes(&instTypeOneReg{operation: opRPINC})
+	for _, s := range a.sections {
+		if s.exec != exec || s.nobits {
+			continue
+		}
+		if pad := s.addr - start - uint64(len(img)); pad > 0 {
+			gap := make([]byte, pad)
+			for i := 0; exec && i+instSize <= len(gap); i += instSize {
+				copy(gap[i:], nop[:])
+			}
+			img = append(img, gap...)
+		}
+		img = append(img, s.data...)
+	}
+	return img
+}
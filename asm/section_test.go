@@ -0,0 +1,77 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func assembleString(t *testing.T, src string) *assembler {
+	a := assembler{}
+	if err := a.load("test.s", strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(); err != nil {
+		t.Fatal(err)
+	}
+	return &a
+}
+
+func TestSectionLayout(t *testing.T) {
+	a := assembleString(t, `
+.data
+d: 1 2 3
+.bss
+b: 0 0
+.section .rodata
+r: 4
+.text
+start: NOP
+.section .init, "ax"
+init: J start
+.data
+d2: 5
+`)
+	var table = []struct {
+		name string
+		addr uint64
+	}{
+		{"start", textStartAddr},
+		{"init", textStartAddr + 4},
+		{"d", dataStartAddr},
+		{"d2", dataStartAddr + 3},
+		{"r", dataStartAddr + 4},
+		{"b", dataStartAddr + 5},
+	}
+	for _, e := range table {
+		if a.symbols[e.name] != e.addr {
+			t.Errorf("%s: 0x%x (expected 0x%x)", e.name, a.symbols[e.name], e.addr)
+		}
+	}
+
+	data := a.segmentImage(false, dataStartAddr)
+	if string(data) != "\x01\x02\x03\x05\x04" {
+		t.Error(data)
+	}
+	if text := a.segmentImage(true, textStartAddr); len(text) != 8 {
+		t.Error(text)
+	}
+}
+
+func TestSectionErrors(t *testing.T) {
+	for _, src := range []string{
+		".data\nNOP",
+		".text\n1 2 3",
+		".bss\n1",
+		`.section .x, "q"`,
+		`.section .x, "ax", @nobits`,
+		".data 1",
+	} {
+		a := assembler{}
+		if err := a.load("test.s", strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.run(); err == nil {
+			t.Errorf("'%s' should be an error", src)
+		}
+	}
+}
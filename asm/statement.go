@@ -0,0 +1,112 @@
+package asm
+
+import (
+	"strings"
+)
+
+// statement is a logical line split into its parts:
+//
+//	[!] [label:]... [%name =] [mnemonic [operand [, operand]...]]   (label: name or number (local label))
+//	[label:]... value value ...
+//
+// '!' marks the entry point of the program. %name names the value the instruction produces
+// (so do the labels), and the operand %name is the distance to it.
+// A line without a mnemonic is a list of bytes (the old form of data).
+// The operands of a directive (".byte 1, 2") are separated only by commas.
+type statement struct {
+	line     *srcLine
+	entry    bool
+	labels   []token
+	name     *token // %name =
+	mnemonic *token // nil for a line of only labels or of bytes
+	operands [][]token
+	hidden   string // the name of the value referred to by the distances rewritten by the assembler ("#3")
+	inserted bool   // put in by the assembler (-relay, -pad), not in the source
+}
+
+func parseStatement(line *srcLine) *statement {
+	st := statement{line: line}
+	tokens := line.tokens
+	if len(tokens) > 0 && tokens[0].text == "!" {
+		st.entry = true
+		tokens = tokens[1:]
+	}
+	for len(tokens) >= 2 && (tokens[0].kind == tokIdent || isNumericLabel(tokens[0].text)) && tokens[1].text == ":" {
+		st.labels = append(st.labels, tokens[0])
+		tokens = tokens[2:]
+	}
+	if len(tokens) >= 3 && tokens[0].text == "%" && tokens[1].kind == tokIdent && !tokens[1].space && tokens[2].text == "=" {
+		st.name = &tokens[1]
+		tokens = tokens[3:]
+	}
+	if len(tokens) == 0 {
+		return &st
+	}
+	if tokens[0].kind != tokIdent {
+		st.operands = splitTokens(tokens)
+		return &st
+	}
+	st.mnemonic = &tokens[0]
+	if st.isDirective() {
+		// directives are new: the operands are always separated by commas
+		st.operands = splitTokensByComma(tokens[1:])
+	} else {
+		st.operands = splitTokens(tokens[1:])
+	}
+	return &st
+}
+
+// attachLabels moves the labels (and '!') of the lines of only labels to the instruction right after them,
+// so that "join:" on its own line is a join and names the value of the next instruction like "join: ADD.64 3 5"
+func attachLabels(stmts []*statement) []*statement {
+	var out, pending []*statement
+	for _, st := range stmts {
+		if st.mnemonic == nil && st.name == nil && len(st.operands) == 0 {
+			pending = append(pending, st)
+			continue
+		}
+		if st.mnemonic != nil && !st.isDirective() {
+			var labels []token
+			for _, p := range pending {
+				labels = append(labels, p.labels...)
+				st.entry = st.entry || p.entry
+			}
+			st.labels = append(labels, st.labels...)
+			pending = nil
+		}
+		out = append(out, pending...)
+		out = append(out, st)
+		pending = nil
+	}
+	return append(out, pending...)
+}
+
+func (st *statement) isDirective() bool {
+	return st.mnemonic != nil && strings.HasPrefix(st.mnemonic.text, ".")
+}
+
+func (st *statement) pos() srcPos {
+	if st.mnemonic != nil {
+		return st.mnemonic.pos
+	}
+	return st.line.pos
+}
+
+func (st *statement) operandTexts() []string {
+	ops := make([]string, len(st.operands))
+	for i, op := range st.operands {
+		ops[i] = joinTokens(op)
+	}
+	return ops
+}
+
+// String returns the instruction in the canonical form "MNEMONIC op1, op2, ..."
+func (st *statement) String() string {
+	if st.mnemonic == nil {
+		return ""
+	}
+	if len(st.operands) == 0 {
+		return st.mnemonic.text
+	}
+	return st.mnemonic.text + " " + strings.Join(st.operandTexts(), ", ")
+}
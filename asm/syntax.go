@@ -0,0 +1,108 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// syntaxes : the dialects of the operands (-syntax)
+//
+//	legacy: ADD.64 3 5        LD.64 2 16        ST.64 1 2 16        SPLD.64 16    SPST.64 1 16
+//	paper:  ADD.64 [3], [5]   LD.64 16([2])     ST.64 [1], 16([2])  SPLD.64 16(SP) SPST.64 [1], 16(SP)
+//
+// The paper form also takes "LD.64 [2], 16". Both produce the same instructions.
+var syntaxes = map[string]bool{"legacy": true, "paper": true}
+
+// Syntaxes returns the names of the dialects of the operands, sorted
+func Syntaxes() []string {
+	names := make([]string, 0, len(syntaxes))
+	for name := range syntaxes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// memOperands : load/store prefix -> the number of the operands in the paper form (the last one is the memory operand)
+var memOperands = map[string]int{"LD.": 1, "ST.": 2, "SPLD.": 1, "SPST.": 2}
+
+// memOperand splits "offset([n])" or "offset(SP)" into the offset and what is in the parentheses
+func memOperand(op []token) (offset, base []token, ok bool) {
+	if len(op) < 3 || op[len(op)-1].text != ")" {
+		return nil, nil, false
+	}
+	depth := 0
+	for i := len(op) - 1; i >= 0; i-- {
+		switch op[i].text {
+		case ")":
+			depth++
+		case "(":
+			depth--
+		}
+		if depth == 0 {
+			return op[:i], op[i+1 : len(op)-1], true
+		}
+	}
+	return nil, nil, false
+}
+
+// isSP reports whether the base of a memory operand is the stack pointer
+func isSP(base []token) bool {
+	return len(base) == 1 && strings.EqualFold(base[0].text, "SP")
+}
+
+// paperOperands rewrites the operands of an instruction in the paper syntax into the legacy ones
+func paperOperands(st *statement) error {
+	m := st.mnemonic.text
+	for prefix, n := range memOperands {
+		if !strings.HasPrefix(m, prefix) || len(st.operands) != n {
+			continue
+		}
+		op := st.operands[n-1]
+		offset, base, ok := memOperand(op)
+		if !ok {
+			continue
+		}
+		sp := strings.HasPrefix(prefix, "SP")
+		if sp != isSP(base) {
+			want := map[bool]string{true: "offset(SP)", false: "offset([n])"}[sp]
+			return lineError(st.line, op[0].pos, fmt.Errorf("the memory operand of %s has to be %s", m, want))
+		}
+		if len(offset) == 0 {
+			offset = []token{{kind: tokNumber, text: "0", pos: op[0].pos}}
+		}
+		ops := append([][]token(nil), st.operands[:n-1]...)
+		if !sp {
+			ops = append(ops, base)
+		}
+		st.operands = append(ops, offset)
+		break
+	}
+
+	for _, k := range distanceOperands(st) {
+		if k >= len(st.operands) {
+			continue
+		}
+		op := st.operands[k]
+		switch {
+		case len(op) >= 3 && op[0].text == "[" && op[len(op)-1].text == "]":
+			st.operands[k] = op[1 : len(op)-1]
+		case len(op) == 1 && op[0].kind == tokNumber:
+			return lineError(st.line, op[0].pos, fmt.Errorf("a distance is written [%s] in the paper syntax", op[0].text))
+		}
+	}
+	return nil
+}
+
+// legacyOperands checks that an instruction in the legacy syntax has no paper form
+func legacyOperands(st *statement) error {
+	for _, op := range st.operands {
+		for _, t := range op {
+			if t.text == "[" {
+				return lineError(st.line, t.pos, fmt.Errorf("[n] is the paper syntax (-syntax=paper)"))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPaperSyntax(t *testing.T) {
+	legacy := `
+%x = LUi 1
+ADD.64 1 2
+FMADD.d 1 2 3
+LD.64 2 16
+LD.32 1 0
+ST.64 1 2 -8
+SPLD.64 16
+SPST.64 1 24
+BEQ 1 %x l
+l: JR 1, 0
+LD.64 d
+RMOV 12
+.data
+d: .dword 1
+`
+	paper := `
+%x = LUi 1
+ADD.64 [1], [2]
+FMADD.d [1], [2], [3]
+LD.64 16([2])
+LD.32 ([1])
+ST.64 [1], -8([2])
+SPLD.64 16(SP)
+SPST.64 [1], 24(sp)
+BEQ [1], [%x], l
+l: JR [1], 0
+LD.64 d
+RMOV [12]
+.data
+d: .dword 1
+`
+	a := assembleString(t, legacy)
+	b := assembler{opts: Options{Syntax: "paper"}}
+	if err := b.load("test.s", strings.NewReader(paper)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.run(); err != nil {
+		t.Fatal(err)
+	}
+	if string(a.sections[0].data) != string(b.sections[0].data) {
+		t.Errorf("paper:\n%x\nlegacy:\n%x", b.sections[0].data, a.sections[0].data)
+	}
+	if b := (assembler{opts: Options{Syntax: "paper"}}); b.load("test.s", strings.NewReader("LD.64 [2], 16")) != nil {
+		t.Error("LD.64 [2], 16 should be accepted")
+	}
+
+	var table = []struct {
+		syntax string
+		in     string
+	}{
+		{"legacy", "ADD.64 [1] [2]"},
+		{"paper", "ADD.64 1, [2]"},
+		{"paper", "LD.64 16(SP)"},
+		{"paper", "SPLD.64 16([1])"},
+	}
+	for _, e := range table {
+		a := assembler{opts: Options{Syntax: e.syntax}}
+		if err := a.load("test.s", strings.NewReader(e.in)); err == nil {
+			t.Errorf("%s %q should be an error", e.syntax, e.in)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package asm
+
+import (
+	"sort"
+	"strings"
+)
+
+func extractBits(imm uint64, bit uint, isSigned bool) uint64 {
+	val := ((1 << bit) - 1) & imm
+	if isSigned && ((imm & (1 << (bit - 1))) != 0) {
+		val |= ^((1 << bit) - 1)
+	}
+	return val
+}
+
+// editDistance is the number of insertions, deletions, substitutions and transpositions of adjacent characters
+// to change a into b
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// similarNames returns the candidates close to name (ignoring case), the closest first
+func similarNames(name string, candidates []string) []string {
+	type scored struct {
+		name string
+		dist int
+	}
+	var found []scored
+	limit := 1 + len(name)/4
+	for _, c := range candidates {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(c)); d <= limit {
+			found = append(found, scored{c, d})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].dist != found[j].dist {
+			return found[i].dist < found[j].dist
+		}
+		return found[i].name < found[j].name
+	})
+	names := make([]string, len(found))
+	for i, f := range found {
+		names[i] = f.name
+	}
+	return names
+}
+
+// didYouMean returns " (did you mean a, b?)" with the names similar to name, or "" if there is none
+func didYouMean(name string, candidates []string) string {
+	similar := similarNames(name, candidates)
+	if len(similar) == 0 {
+		return ""
+	}
+	if len(similar) > 3 {
+		similar = similar[:3]
+	}
+	return " (did you mean " + strings.Join(similar, ", ") + "?)"
+}
@@ -0,0 +1,147 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// maxDistance : the largest distance a source operand can have (7 bit)
+const maxDistance = 1<<7 - 1
+
+// valueDef is the producer of a named value ("%sum = ADD.64 3 5" or "sum: ADD.64 3 5")
+type valueDef struct {
+	stmt int // index in a.stmts
+	sec  *section
+	pc   uint64 // the instruction that produces the value (the last one of a pseudo-instruction)
+}
+
+// unconditionalJumps : the instructions after which the next one is not executed right after them
+var unconditionalJumps = map[string]bool{
+	"J": true, "JAL": true, "JR": true, "JALR": true,
+	"CALL": true, "TAIL": true, "RET": true,
+}
+
+// valueRef returns the name of an operand that refers to a named value ("%sum")
+func valueRef(op []token) (string, bool) {
+	if len(op) == 2 && op[0].text == "%" && op[1].kind == tokIdent && !op[1].space {
+		return op[1].text, true
+	}
+	return "", false
+}
+
+// findJoins collects the labels that other paths can come in through:
+// the ones referenced in any way other than as a named value (branch targets, addresses, ...)
+func (a *assembler) findJoins() {
+	a.joins = map[string]bool{}
+	for _, st := range a.stmts {
+		for _, op := range st.operands {
+			for i, t := range op {
+				if t.kind == tokIdent && !(i > 0 && op[i-1].text == "%" && !t.space) {
+					a.joins[t.text] = true
+				}
+			}
+		}
+	}
+}
+
+// pathBreak returns why the straight-line path ends at st (before executing it), or ""
+func (a *assembler) pathBreak(st *statement) string {
+	if st.entry {
+		return "the entry point"
+	}
+	for _, l := range st.labels {
+		if a.joins[l.text] {
+			return fmt.Sprintf("label '%s' (a branch target)", l.text)
+		}
+	}
+	return ""
+}
+
+// defineValue records st as the producer of its named values
+func (a *assembler) defineValue(st *statement) {
+	def := valueDef{stmt: a.stmtNo, sec: a.cur, pc: a.cur.pc() - instSize}
+	if st.name != nil {
+		a.values[st.name.text] = def
+	}
+	for _, l := range st.labels {
+		a.values[l.text] = def
+	}
+	if st.hidden != "" {
+		a.values[st.hidden] = def
+	}
+}
+
+// valueDistance returns the distance from the current instruction to the producer of the named value
+func (a *assembler) valueDistance(name string) (int, error) {
+	def, ok := a.values[name]
+	if !ok {
+		names := make([]string, 0, len(a.values))
+		for n := range a.values {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		var fixes []fixIt
+		for _, fix := range replacements(name, names) {
+			fixes = append(fixes, fixIt{old: "%" + fix.old, new: "%" + fix.new})
+		}
+		return 0, withCode("undefined-value", fmt.Errorf("no value named %%%s before this instruction%s", name, didYouMean(name, names)), fixes...)
+	}
+	what := fmt.Sprintf("%%%s (%s)", name, a.stmts[def.stmt].pos())
+	if isHidden(name) {
+		what = fmt.Sprintf("the value of %s", a.stmts[def.stmt].pos())
+	}
+	if def.sec != a.cur {
+		return 0, fmt.Errorf("%s is produced in another section %s", what, def.sec.name)
+	}
+	for i := def.stmt; i <= a.stmtNo && !isHidden(name); i++ {
+		st := a.stmts[i]
+		reason := ""
+		if i > def.stmt {
+			reason = a.pathBreak(st)
+		}
+		if reason == "" && i < a.stmtNo && st.mnemonic != nil && unconditionalJumps[st.mnemonic.text] {
+			reason = st.mnemonic.text
+		}
+		if reason != "" {
+			return 0, fmt.Errorf("%s is not on the straight-line path to here: %s at %s", what, reason, st.pos())
+		}
+	}
+	d := int((a.cur.pc() - def.pc) / instSize)
+	if d > maxDistance {
+		if a.opts.Relay && !a.final {
+			a.longRefs = append(a.longRefs, longRef{stmt: a.stmtNo, name: name, def: def})
+			return 1, nil
+		}
+		return 0, withCode("distance-range", fmt.Errorf("%s is %d instructions back (max %d)", what, d, maxDistance))
+	}
+	return d, nil
+}
+
+// resolveValues returns st with the references to named values replaced with the distances
+func (a *assembler) resolveValues(st *statement) (*statement, error) {
+	var resolved *statement
+	for i, op := range st.operands {
+		name, ok := valueRef(op)
+		if !ok {
+			continue
+		}
+		d, err := a.valueDistance(name)
+		if err != nil {
+			if a.final {
+				return nil, err
+			}
+			d = 1 // the layout may change yet
+		}
+		if resolved == nil {
+			copied := *st
+			copied.operands = append([][]token(nil), st.operands...)
+			resolved = &copied
+		}
+		resolved.operands[i] = []token{{kind: tokNumber, text: strconv.Itoa(d), pos: op[0].pos, space: op[0].space}}
+	}
+	if resolved == nil {
+		return st, nil
+	}
+	return resolved, nil
+}
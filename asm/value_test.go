@@ -0,0 +1,46 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNamedValues(t *testing.T) {
+	a := assembleString(t, `
+%sum = ADD.64 3 5
+n: LI.64 0x12345678
+SUB.64 %sum %n
+BEQZ %sum, skip
+%sum = ADD.64 %sum, 1
+skip: NOP
+`)
+	checkText(t, a, []string{"ADD.64 3, 5", "LUi 0x12345", "ADDi.64 1, 0x678", "SUB.64 3, 1", "LUi 0", "BEQ 5, 1, skip", "ADD.64 6, 1", "NOP"})
+
+	// a label on its own line names the value of the next instruction
+	for _, src := range []string{"sum: ADD.64 3 5\nNOP\nRMOV %sum\n", "sum:\nADD.64 3 5\nNOP\nRMOV %sum\n", "sum:\n\n  s:\nADD.64 3 5\nNOP\nRMOV %s\n"} {
+		a := assembleString(t, src)
+		checkText(t, a, []string{"ADD.64 3, 5", "NOP", "RMOV 2"})
+	}
+
+	var table = []struct {
+		in       string
+		expected string
+	}{
+		{"RMOV %x", "test.s:1:1: no value named %x before this instruction"},
+		{"%sum = ADD.64 1 2\nRMOV %sun", "(did you mean sum?)"},
+		{"%x = NOP\nl: NOP\nJ l\nRMOV %x", "%x (test.s:1:6) is not on the straight-line path to here: label 'l' (a branch target) at test.s:2:4"},
+		{"%x = NOP\nJ 10\nRMOV %x", "not on the straight-line path to here: J at test.s:2:1"},
+		{"%x = NOP\n.space 512\nRMOV %x", "%x (test.s:1:6) is 129 instructions back (max 127)"},
+		{"%x = .word 1", "%x = has to name an instruction"},
+	}
+	for _, e := range table {
+		a := assembler{}
+		err := a.load("test.s", strings.NewReader(e.in))
+		if err == nil {
+			err = a.run()
+		}
+		if err == nil || !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%q: %v (expected %q)", e.in, err, e.expected)
+		}
+	}
+}
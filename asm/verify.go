@@ -0,0 +1,268 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxVerifyPaths : limit of the paths followed back from an operand (the operand is not checked beyond it)
+const maxVerifyPaths = 256
+
+// emitted is an instruction generated in the final pass
+type emitted struct {
+	sec  *section
+	pc   uint64
+	inst instruction
+	stmt int    // index in a.stmts
+	text string // the instruction as assembled ("ADD.64 1, 2")
+}
+
+// instDistances returns the distances to the source values of the instruction
+func instDistances(i instruction) []uint32 {
+	switch i := i.(type) {
+	case *instTypeSB:
+		return i.srcRegs[:]
+	case *instTypeTwoReg:
+		return i.srcRegs[:]
+	case *instTypeMAC:
+		return i.srcRegs[:]
+	case *instTypeFloat:
+		return i.srcRegs[:floatSources(i.operation)]
+	case *instTypeOneReg:
+		switch i.operation {
+		case opRPINC, opFENCE, opFENCEI, opECALL, opCSRRWi, opCSRRSi, opCSRRCi,
+			opSPLD8, opSPLD16, opSPLD32, opSPLD64, opSPLD8u, opSPLD16u, opSPLD32u, opSPLD32f:
+			return nil
+		}
+		return []uint32{i.srcReg}
+	}
+	return nil
+}
+
+// controlFlow tells how the instruction at pc passes the control:
+// the target of a branch or a jump (ok == false for none), whether the next instruction follows it
+// and whether it is a call (the next one follows after the callee returns)
+func controlFlow(i instruction, pc uint64) (target uint64, ok, next, call bool) {
+	switch i := i.(type) {
+	case *instTypeSB:
+		if isBranch(i.operation) {
+			return pc + uint64(extractBits(uint64(i.imm12), 12, true)*instSize), true, true, false
+		}
+	case *instTypeNoReg:
+		if i.operation == opJ || i.operation == opJAL {
+			target := pc + uint64(extractBits(uint64(i.imm20), 20, true)*instSize)
+			return target, true, i.operation == opJAL, i.operation == opJAL
+		}
+	case *instTypeOneReg:
+		switch i.operation {
+		case opJR:
+			return 0, false, false, false
+		case opJALR:
+			return 0, false, true, true
+		}
+	}
+	return 0, false, true, false
+}
+
+// cfg is the control flow graph of the instructions of the final pass
+type cfg struct {
+	insts   []emitted
+	preds   [][]int // the instructions that can be executed right before each one
+	unknown []bool  // the instruction can also be reached from somewhere unknown
+}
+
+// buildCFG connects the instructions by the fall-throughs and the branches and jumps with a known target.
+// The entry, the beginning of a section and the returns from calls come from unknown places.
+func (a *assembler) buildCFG() *cfg {
+	g := &cfg{
+		insts:   a.insts,
+		preds:   make([][]int, len(a.insts)),
+		unknown: make([]bool, len(a.insts)),
+	}
+	at := map[uint64]int{}
+	for n, e := range a.insts {
+		at[e.pc] = n
+		if e.pc == a.entry {
+			g.unknown[n] = true
+		}
+	}
+	for n, e := range a.insts {
+		target, ok, next, call := controlFlow(e.inst, e.pc)
+		if ok {
+			if t, found := at[target]; found {
+				g.preds[t] = append(g.preds[t], n)
+			}
+		}
+		if n+1 < len(a.insts) && a.insts[n+1].sec == e.sec && a.insts[n+1].pc == e.pc+instSize {
+			if call {
+				g.unknown[n+1] = true
+			} else if next {
+				g.preds[n+1] = append(g.preds[n+1], n)
+			}
+		}
+	}
+	for n, e := range a.insts {
+		if n == 0 || a.insts[n-1].sec != e.sec || a.insts[n-1].pc != e.pc-instSize {
+			g.unknown[n] = true
+		}
+	}
+	return g
+}
+
+// path is a way back from an operand to its producer: the instructions executed, the nearest first
+type path []int
+
+// paths returns the paths of d instructions back from the instruction n,
+// leaving out the ones that reach an unknown place. ok is false beyond maxVerifyPaths.
+func (g *cfg) paths(n int, d int) (paths []path, ok bool) {
+	var walk func(n int, p path) bool
+	walk = func(n int, p path) bool {
+		if len(p) == d {
+			paths = append(paths, append(path(nil), p...))
+			return len(paths) <= maxVerifyPaths
+		}
+		if g.unknown[n] && len(g.preds[n]) == 0 {
+			return true
+		}
+		for _, m := range g.preds[n] {
+			if !walk(m, append(p, m)) {
+				return false
+			}
+		}
+		return true
+	}
+	ok = walk(n, nil)
+	return paths, ok
+}
+
+// reach returns the instructions d back from the instruction n on any of the paths, without following them one by one
+func (g *cfg) reach(n int, d int) map[int]bool {
+	at := map[int]bool{n: true}
+	for k := 0; k < d; k++ {
+		prev := map[int]bool{}
+		for m := range at {
+			for _, p := range g.preds[m] {
+				prev[p] = true
+			}
+		}
+		at = prev
+	}
+	return at
+}
+
+// valueName returns the name the source gives to the value of the instruction, or ""
+func (a *assembler) valueName(n int) string {
+	e := a.insts[n]
+	if n+1 < len(a.insts) && a.insts[n+1].stmt == e.stmt {
+		return "" // in the middle of a pseudo-instruction
+	}
+	st := a.stmts[e.stmt]
+	if st.name != nil {
+		return st.name.text
+	}
+	return ""
+}
+
+// describeInst returns "MNEMONIC at file:line:col" for the instruction n
+func (a *assembler) describeInst(n int) string {
+	st := a.stmts[a.insts[n].stmt]
+	return fmt.Sprintf("%s at %s", st.mnemonic.text, st.pos())
+}
+
+// describePath returns the branches and the jumps taken in the path
+func (a *assembler) describePath(g *cfg, n int, p path) string {
+	var taken []string
+	for _, m := range p {
+		if g.insts[m].pc+instSize != g.insts[n].pc {
+			taken = append(taken, a.describeInst(m))
+		}
+		n = m
+	}
+	if len(taken) == 0 {
+		return "falling through"
+	}
+	return "via " + strings.Join(taken, ", ")
+}
+
+// mergePoint returns the label (or the address) of the instruction where the paths p and q from n come together
+func (a *assembler) mergePoint(g *cfg, n int, p, q path) string {
+	m := n
+	for i := 0; i < len(p) && p[i] == q[i]; i++ {
+		m = p[i]
+	}
+	if labels := a.labelsAt(g.insts[m].stmt); len(labels) > 0 {
+		return fmt.Sprintf("label '%s'", labels[0].text)
+	}
+	return fmt.Sprintf("0x%x", g.insts[m].pc)
+}
+
+// labelsAt returns the labels of the statement s and of the statements right before it that put nothing
+// at its address (a line of only labels before a directive, for example), the nearest last
+func (a *assembler) labelsAt(s int) []token {
+	labels := a.stmts[s].labels
+	p := a.placed[s]
+	for k := s - 1; k >= 0; k-- {
+		q := a.placed[k]
+		if _, ok := a.data[k]; ok || q.sec != p.sec || q.start != p.start || q.end != q.start {
+			break
+		}
+		labels = append(append([]token(nil), a.stmts[k].labels...), labels...)
+	}
+	return labels
+}
+
+// conflicts reports whether the producers at the ends of p and q are different values: one of them is
+// executed in the other path at another distance, or they are named differently
+func (a *assembler) conflicts(p, q path) bool {
+	x, y := p[len(p)-1], q[len(q)-1]
+	if x == y {
+		return false
+	}
+	for _, m := range q {
+		if m == x {
+			return true
+		}
+	}
+	for _, m := range p {
+		if m == y {
+			return true
+		}
+	}
+	nx, ny := a.valueName(x), a.valueName(y)
+	return nx != "" && ny != "" && nx != ny
+}
+
+// verifyJoins checks that every source operand refers to the same value along all the paths into it
+func (a *assembler) verifyJoins() error {
+	g := a.buildCFG()
+	var errs []error
+	for n, e := range g.insts {
+		for k, d := range instDistances(e.inst) {
+			if d == 0 {
+				continue
+			}
+			paths, ok := g.paths(n, int(d))
+			if !ok || len(paths) < 2 {
+				continue
+			}
+		check:
+			for i := range paths {
+				for j := i + 1; j < len(paths); j++ {
+					if !a.conflicts(paths[i], paths[j]) {
+						continue
+					}
+					st := a.stmts[e.stmt]
+					p, q := paths[i], paths[j]
+					err := fmt.Errorf("operand %d (distance %d) of %s after %s refers to different values:\n\t%s: %s\n\t%s: %s",
+						k+1, d, st.mnemonic.text, a.mergePoint(g, n, p, q),
+						a.describePath(g, n, p), a.describeInst(p[len(p)-1]),
+						a.describePath(g, n, q), a.describeInst(q[len(q)-1]))
+					errs = append(errs, lineError(st.line, st.pos(), err))
+					break check
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,43 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyJoins(t *testing.T) {
+	var table = []struct {
+		in       string
+		expected string // "" for no error
+	}{
+		// the arms of the same length
+		{"LUi 1\nBEQ 1, 1, else\nADDi.64 2, 1\nJ join\nelse: ADDi.64 2, 2\nNOP\njoin: RMOV 2\nRMOV 5", ""},
+		// a loop-carried value
+		{"LUi 0\nNOP\nloop: ADDi.64 2, 1\nBNE 1, 1, loop", ""},
+		// the else arm is shorter
+		{"LUi 1\nBEQ 1, 1, else\nADDi.64 2, 1\nJ join\nelse: ADDi.64 2, 2\njoin: RMOV 3",
+			"test.s:6:7: operand 1 (distance 3) of RMOV after label 'join' refers to different values:\n" +
+				"\tvia J at test.s:4:1: BEQ at test.s:2:1\n" +
+				"\tvia BEQ at test.s:2:1: LUi at test.s:1:1"},
+		// the label on its own line
+		{"LUi 1\nBEQ 1, 1, else\nADDi.64 2, 1\nJ join\nelse:\nADDi.64 2, 2\njoin:\nRMOV 3",
+			"test.s:8:1: operand 1 (distance 3) of RMOV after label 'join' refers to different values"},
+		{"LUi 1\nBEQ 1, 1, else\nADDi.64 2, 1\nJ join\nelse: ADDi.64 2, 2\njoin:\n.align 2\nRMOV 3",
+			"of RMOV after label 'join' refers to different values"},
+		// the names differ
+		{"LUi 1\nBEQ 1, 1, else\n%a = ADDi.64 2, 1\nJ join\nelse: %b = ADDi.64 2, 2\nNOP\njoin: RMOV 2",
+			"of RMOV after label 'join' refers to different values"},
+		// the return from a call is not followed
+		{"JAL f\nRMOV 1\nf: RMOV 1\nJR 1, 0", ""},
+	}
+	for _, e := range table {
+		a := assembler{opts: Options{Verify: true}}
+		err := a.load("test.s", strings.NewReader(e.in))
+		if err == nil {
+			err = a.run()
+		}
+		if e.expected == "" && err != nil || e.expected != "" && (err == nil || !strings.Contains(err.Error(), e.expected)) {
+			t.Errorf("%q: %v (expected %q)", e.in, err, e.expected)
+		}
+	}
+}
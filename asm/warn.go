@@ -0,0 +1,249 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// warningNames : the categories of the warnings -> enabled without -Wall
+var warningNames = map[string]bool{
+	"truncation":    true,  // an immediate encoded as another value
+	"unreachable":   true,  // an instruction right after J/JR that nothing branches to
+	"rounding-mode": true,  // a rounding mode on a float operation that does not round
+	"unused-label":  false, // a label nothing refers to
+	"unused-result": false, // a result no instruction refers to within maxDistance
+	"padding":       true,  // a numeric distance over the NOPs of .align or .space
+}
+
+// DefaultWarnings returns the warnings enabled without -Wall
+func DefaultWarnings() map[string]bool {
+	enabled := map[string]bool{}
+	for name, on := range warningNames {
+		enabled[name] = on
+	}
+	return enabled
+}
+
+// Warnings returns the names of the categories, sorted
+func Warnings() []string {
+	names := make([]string, 0, len(warningNames))
+	for name := range warningNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// warn adds a warning of the category at pos (in the final pass, if the category is enabled).
+// With -Werror it is an error.
+func (a *assembler) warn(name string, line *srcLine, pos srcPos, format string, args ...any) {
+	enabled := a.opts.Warnings
+	if enabled == nil {
+		enabled = warningNames
+	}
+	if !a.final || !enabled[name] {
+		return
+	}
+	sev := sevWarning
+	if a.opts.Werror {
+		sev = sevError
+	}
+	a.diags = append(a.diags, diagnostic{sev: sev, code: name, pos: pos, line: line, msg: fmt.Sprintf(format, args...)})
+}
+
+// nonRoundingFloatOps : the float operations the rounding mode does not matter to
+var nonRoundingFloatOps = map[floatOperation]bool{
+	opFSGNJs: true, opFSGNJNs: true, opFSGNJXs: true, opFMINs: true, opFMAXs: true, opFCLASSs: true, opFEQs: true, opFLTs: true, opFLEs: true,
+	opFSGNJd: true, opFSGNJNd: true, opFSGNJXd: true, opFMINd: true, opFMAXd: true, opFCLASSd: true, opFEQd: true, opFLTd: true, opFLEd: true,
+}
+
+// checkInst warns about the instruction i made from s for the statement st: the immediates encoded
+// as other values and the rounding modes that do nothing
+func (a *assembler) checkInst(st *statement, s string, i instruction) {
+	ss := splitFields(s)
+	switch i := i.(type) {
+	case *instTypeOneReg:
+		// the logical immediates are bit patterns ("ANDi.64 1 0xfff" is "ANDi.64 1 -1"), but SLTiu compares with the value
+		if (i.operation == opSLTiu32 || i.operation == opSLTiu64) && len(ss) >= 3 {
+			if v, err := evalExpr(ss[2], a.env()); err == nil && v.val >= 1<<11 {
+				a.warn("truncation", st.line, st.pos(), "'%s' = %d is sign-extended to %d in %s", ss[2], v.val, v.val-1<<12, s)
+			}
+		}
+	case *instTypeFloat:
+		pos := st.pos()
+		if n := len(st.operands); n > 0 {
+			pos = st.operands[n-1][0].pos
+		}
+		switch {
+		case nonRoundingFloatOps[i.operation] && len(ss) >= 4:
+			a.warn("rounding-mode", st.line, pos, "%s does not round: the rounding mode %s does nothing", ss[0], ss[3])
+		case floatSources(i.operation) == 1 && len(ss) >= 3:
+			a.warn("rounding-mode", st.line, pos, "the rounding mode %s of %s is not encoded (the instruction has no field for it)", ss[2], ss[0])
+		}
+	}
+}
+
+// sourceLabel returns the label as written in the source (".L1$3" -> "1", ".Lloop$f" -> ".Lloop")
+func sourceLabel(name string) string {
+	name, _, _ = strings.Cut(name, "$")
+	if n := strings.TrimPrefix(name, ".L"); isNumericLabel(n) {
+		return n
+	}
+	return name
+}
+
+// checkLabels warns about the labels nothing refers to (the entry point is referred to by '!')
+func (a *assembler) checkLabels() {
+	referenced := map[string]bool{}
+	for _, st := range a.stmts {
+		for _, op := range st.operands {
+			for _, t := range op {
+				if t.kind == tokIdent {
+					referenced[t.text] = true
+				}
+			}
+		}
+	}
+	for _, st := range a.stmts {
+		for _, l := range st.labels {
+			if !referenced[l.text] && !st.entry {
+				a.warn("unused-label", st.line, l.pos, "label '%s' is not used", sourceLabel(l.text))
+			}
+		}
+	}
+}
+
+// producesValue reports whether the result of the instruction is meant to be used
+// (not a store, a branch, a jump, a call, NOP, SPADDi, a CSR access or a system instruction)
+func producesValue(i instruction) bool {
+	switch i := i.(type) {
+	case *instTypeSB:
+		return false
+	case *instTypeNoReg:
+		return i.operation != opJ && i.operation != opJAL && i.operation != opSPADDi
+	case *instTypeOneReg:
+		switch i.operation {
+		case opRPINC, opFENCE, opFENCEI, opECALL, opJR, opJALR,
+			opCSRRW, opCSRRS, opCSRRC, opCSRRWi, opCSRRSi, opCSRRCi,
+			opSPST8, opSPST16, opSPST32, opSPST64:
+			return false
+		}
+	}
+	return true
+}
+
+// leavesCode reports whether the control goes where the distances can't be followed from the instruction:
+// a call, a return or an indirect jump, or a system call
+func leavesCode(i instruction) bool {
+	if i, ok := i.(*instTypeOneReg); ok && i.operation == opECALL {
+		return true
+	}
+	_, _, next, call := controlFlow(i, 0)
+	if _, ok := i.(*instTypeOneReg); ok && !next {
+		return true // JR
+	}
+	return call
+}
+
+// checkFlow warns about the instructions no path reaches and the results no path uses
+func (a *assembler) checkFlow() {
+	g := a.buildCFG()
+	succs := make([][]int, len(g.insts))
+	for n, preds := range g.preds {
+		for _, m := range preds {
+			succs[m] = append(succs[m], n)
+		}
+	}
+
+	for n, e := range g.insts {
+		st := a.stmts[e.stmt]
+		if n == 0 || g.unknown[n] || len(g.preds[n]) > 0 || g.insts[n-1].stmt == e.stmt || st.isDirective() {
+			continue
+		}
+		if _, _, next, _ := controlFlow(g.insts[n-1].inst, g.insts[n-1].pc); !next && len(st.labels) == 0 {
+			a.warn("unreachable", st.line, st.pos(), "unreachable instruction after %s", a.describeInst(n-1))
+		}
+	}
+
+	used := make([]bool, len(g.insts))
+	for n, e := range g.insts {
+		for _, d := range instDistances(e.inst) {
+			if d == 0 || d > maxDistance {
+				continue
+			}
+			paths, ok := g.paths(n, int(d))
+			if !ok {
+				// too many paths to follow: any instruction d back may be the one
+				for m := range g.reach(n, int(d)) {
+					used[m] = true
+				}
+				continue
+			}
+			for _, p := range paths {
+				used[p[len(p)-1]] = true
+			}
+		}
+	}
+	for n, e := range g.insts {
+		if used[n] || !producesValue(e.inst) || (n+1 < len(g.insts) && g.insts[n+1].stmt == e.stmt) {
+			continue
+		}
+		// the instructions the result can be referred to from: the ones within maxDistance on the paths from it
+		known := true
+		depth := map[int]int{n: 0}
+		queue := []int{n}
+		for len(queue) > 0 && known {
+			m := queue[0]
+			queue = queue[1:]
+			if leavesCode(g.insts[m].inst) {
+				known = false
+			}
+			if depth[m] == maxDistance {
+				continue
+			}
+			for _, s := range succs[m] {
+				if _, seen := depth[s]; !seen {
+					depth[s] = depth[m] + 1
+					queue = append(queue, s)
+				}
+			}
+		}
+		if known {
+			st := a.stmts[e.stmt]
+			a.warn("unused-result", st.line, st.pos(), "the result of %s is not used within %d instructions", st.mnemonic.text, maxDistance)
+		}
+	}
+}
+
+// checkPadding warns about the distances written as numbers that go over the NOPs .align and .space
+// put in an executable section (the ones to named values count them)
+func (a *assembler) checkPadding() {
+	g := a.buildCFG()
+	for n, e := range g.insts {
+		st := a.stmts[e.stmt]
+		if st.isDirective() {
+			continue
+		}
+	operands:
+		for k, d := range instDistances(e.inst) {
+			if d == 0 || d > maxDistance {
+				continue
+			}
+			if k < len(st.operands) {
+				if _, named := valueRef(st.operands[k]); named {
+					continue
+				}
+			}
+			paths, _ := g.paths(n, int(d))
+			for _, p := range paths {
+				for _, m := range p[:len(p)-1] {
+					if pad := a.stmts[g.insts[m].stmt]; pad.isDirective() {
+						a.warn("padding", st.line, st.pos(), "distance %d of %s goes over the NOPs of %s at %s", d, st.mnemonic.text, pad.mnemonic.text, pad.pos())
+						continue operands
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+// warningsOf assembles src and returns the codes of the diagnostics and the error of the run
+func warningsOf(t *testing.T, src string, opts Options) ([]string, error) {
+	a := assembler{opts: opts}
+	if err := a.load("test.s", strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	err := a.run()
+	var codes []string
+	for _, d := range a.diags {
+		codes = append(codes, d.code)
+	}
+	return codes, err
+}
+
+func TestWarnings(t *testing.T) {
+	all := map[string]bool{}
+	for _, name := range Warnings() {
+		all[name] = true
+	}
+	var table = []struct {
+		src  string
+		want string
+	}{
+		{"SLTiu.64 1, 0x800\nECALL", "truncation"},
+		{"LUi 1\nLUi 2\nFEQ.64 1, 2, RNE\nECALL", "rounding-mode"},
+		{"LUi 1\nFCVT.32.d 1, RTZ\nECALL", "rounding-mode"},
+		{"l: LUi 1\nECALL", "unused-label"},
+		{"LUi 1\nJR 1, 0\nRMOV 1\nECALL", "unreachable"},
+		{"LUi 1\nLUi 2\nST.64 1, 1, 0\nl: J l", "unused-result"},
+		{"LUi 1\nRMOV 1\nJR 2, 0", ""},                            // the return value
+		{"LUi 1\nBEQ 1, 1, l\nLUi 2\nl: RMOV 1\nECALL", ""},       // used on one of the paths
+		{"! start: LUi 1\nECALL", ""},                             // the entry
+		{"LUi 1\nJ l\nl: RMOV 2\nECALL", ""},                      // a distance over the jump
+		{"LUi 1\nANDi.64 1, 0x7ff\nFADD.64 1, 2, RTZ\nECALL", ""}, // the rounding operation
+		{"LUi 1\nANDi.64 1, 0xfff\nORi.32 1, 0x800\nECALL", ""},   // the bit patterns
+		{"LUi 1\n.align 3\nRMOV 2\nECALL", "padding"},
+		{"%x = LUi 1\n.align 3\nRMOV %x\nECALL", ""}, // the NOP counted
+		{"J l\n.align 3\nl: ECALL", ""},              // the NOP after J
+	}
+	for _, e := range table {
+		codes, err := warningsOf(t, e.src, Options{Warnings: all})
+		if err != nil {
+			t.Errorf("%q: %v", e.src, err)
+			continue
+		}
+		if got := strings.Join(codes, ","); got != e.want {
+			t.Errorf("%q: %q (expected %q)", e.src, got, e.want)
+		}
+	}
+
+	// a label makes the instruction after J reachable, also on the line before it
+	for _, src := range []string{"J 0\nf: RMOV 1\n.data\n.dword f", "J 0\nf:\nRMOV 1\n.data\n.dword f"} {
+		if codes, err := warningsOf(t, src, Options{}); err != nil || len(codes) != 0 {
+			t.Errorf("%q: %v %v", src, codes, err)
+		}
+	}
+
+	// an operand with too many paths to follow leaves the results after it checked
+	src := "LUi 1\n" + strings.Repeat("BEQ 1, 1, 2\nNOP\n", 10) + "ADD.64 14, 14\nLUi 3\nl: J l"
+	if codes, _ := warningsOf(t, src, Options{Warnings: all}); !strings.Contains(strings.Join(codes, ","), "unused-result") {
+		t.Errorf("too many paths: %v", codes)
+	}
+
+	// unused-label is not a default one, and -Werror makes the warnings errors
+	if codes, _ := warningsOf(t, "l: LUi 1\nECALL", Options{}); len(codes) != 0 {
+		t.Errorf("default: %v", codes)
+	}
+	if _, err := warningsOf(t, "SLTiu.64 1, 0x800\nECALL", Options{Werror: true}); err == nil ||
+		!strings.Contains(err.Error(), "sign-extended to -2048") {
+		t.Errorf("-Werror: %v", err)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/clkbug/sasm2
+
+go 1.22
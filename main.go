@@ -2,16 +2,131 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/clkbug/sasm2/asm"
 )
 
+// stringList : a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// writeFile creates the file and writes it with write
+func writeFile(fileName string, write func(w io.Writer) error) error {
+	fp, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := write(fp); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// assemble assembles the file into the ELF file (and the listing and the map if given) and returns the diagnostics
+func assemble(fileName, outputFileName, listing, mapFile string, opts asm.Options) asm.Diagnostics {
+	var diags asm.Diagnostics
+	fp, err := os.Open(fileName)
+	if err != nil {
+		diags.AddError(err)
+		return diags
+	}
+	defer fp.Close()
+
+	obj, err := asm.New(opts).AssembleNamed(fileName, fp)
+	if err != nil {
+		diags.AddError(err)
+		return diags
+	}
+	diags = obj.Diagnostics
+	if listing != "" {
+		if err := writeFile(listing, obj.WriteListing); err != nil {
+			diags.AddError(err)
+		}
+	}
+	if mapFile != "" {
+		if err := writeFile(mapFile, obj.WriteMap); err != nil {
+			diags.AddError(err)
+		}
+	}
+	if err := writeFile(outputFileName, obj.WriteELF); err != nil {
+		diags.AddError(err)
+	}
+	return diags
+}
+
 func main() {
 	var fileName = flag.String("file", "", "アセンブリファイルを指定する")
 	var outputFileName = flag.String("output", "", "出力ファイルを指定する")
+	var opts asm.Options
+	var defines stringList
+	flag.Var((*stringList)(&opts.IncludeDirs), "I", ".include/.incbin でファイルを探すディレクトリを追加する (複数指定可)")
+	flag.BoolVar(&opts.Relay, "relay", false, "127 命令より遠い値の参照に RMOV の中継を挿入する")
+	flag.BoolVar(&opts.Pad, "pad", false, "合流点に入る経路で名前付きの値の距離がそろうように NOP か RMOV を挿入する")
+	flag.BoolVar(&opts.Verify, "verify", false, "合流点に入るすべての経路で距離が同じ値を指すか検査する")
+	flag.Var(&defines, "D", "NAME=value の定数を定義する (複数指定可, value を省略すると 1)")
+
+	flag.StringVar(&opts.Syntax, "syntax", "legacy", "オペランドの書き方 (legacy: ADD.64 3 5, paper: ADD.64 [3], [5])")
+	var diagnosticsFormat = flag.String("diagnostics-format", "text", "エラーと警告の出力形式 (text, json, sarif)")
+	var listing = flag.String("listing", "", "アドレス, 機械語, ソースを並べたリストを出力するファイルを指定する")
+	var mapFile = flag.String("map", "", "セグメント, セクション, シンボルの配置を出力するファイルを指定する")
+	var wall = flag.Bool("Wall", false, "すべての警告を有効にする")
+	flag.BoolVar(&opts.Werror, "Werror", false, "警告をエラーにする")
+	enable, disable := map[string]*bool{}, map[string]*bool{}
+	for _, name := range asm.Warnings() {
+		enable[name] = flag.Bool("W"+name, false, "警告 "+name+" を有効にする")
+		disable[name] = flag.Bool("Wno-"+name, false, "警告 "+name+" を無効にする")
+	}
 
 	flag.Parse()
 
-	err := assemble(*fileName, *outputFileName)
-	if err != nil {
-		println(err.Error())
+	if !slices.Contains(asm.DiagnosticsFormats(), *diagnosticsFormat) {
+		fmt.Fprintf(os.Stderr, "unknown diagnostics format '%s' (%s)\n", *diagnosticsFormat, strings.Join(asm.DiagnosticsFormats(), ", "))
+		os.Exit(1)
+	}
+
+	// the errors of the flags are diagnostics like the ones of the source
+	var diags asm.Diagnostics
+	if !slices.Contains(asm.Syntaxes(), opts.Syntax) {
+		diags.AddError(fmt.Errorf("unknown syntax '%s' (%s)", opts.Syntax, strings.Join(asm.Syntaxes(), ", ")))
+	}
+
+	opts.Warnings = asm.DefaultWarnings()
+	for name := range opts.Warnings {
+		opts.Warnings[name] = (opts.Warnings[name] || *wall || *enable[name]) && !*disable[name]
+	}
+
+	opts.Defines = map[string]int64{}
+	for _, d := range defines {
+		name, v, err := asm.ParseDefine(d)
+		if err != nil {
+			diags.AddError(err)
+			continue
+		}
+		opts.Defines[name] = v
+	}
+
+	if diags.Errors() == 0 {
+		diags = assemble(*fileName, *outputFileName, *listing, *mapFile, opts)
+	}
+	if err := diags.WriteFormat(os.Stderr, *diagnosticsFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if diags.Errors() > 0 {
+		os.Exit(1)
 	}
 }